@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/moshee/logrotate/rotator"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd socket
+// activation.
+const listenFdsStart = 3
+
+// activatedInputs returns the file descriptors passed to this process by
+// systemd socket activation, such as connected sockets with Accept=yes or
+// FIFOs, as inputs, or nil if it was not socket-activated. Like
+// sd_listen_fds(3), it unsets the variables describing them, so that they
+// aren't inherited.
+func activatedInputs() []rotator.Input {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	inputs := make([]rotator.Input, 0, n)
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		inputs = append(inputs, rotator.Input{Reader: f})
+	}
+	return inputs
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/moshee/logrotate/rotator"
+)
+
+// runCommand runs the list or prune subcommand against the archives
+// described by cfg.
+func runCommand(cmd string, cfg rotator.Config) error {
+	switch cmd {
+	case "list":
+		arcs, err := rotator.Archives(cfg)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "SEQ\tSIZE\tMODIFIED\tFILES")
+		for _, a := range arcs {
+			fmt.Fprintf(w, "%d\t%d\t%s\t%s\n", a.Seq, a.Size, a.ModTime.Format(time.RFC3339), strings.Join(a.Files, " "))
+		}
+		return w.Flush()
+
+	case "prune":
+		return rotator.Prune(cfg)
+	}
+	return fmt.Errorf("unknown command %q", cmd)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moshee/logrotate/rotator"
+)
+
+// sizeFlag is a flag.Value holding a byte count. It accepts an optional k, M,
+// G or T suffix (powers of 1000, like -c).
+type sizeFlag int64
+
+func (s *sizeFlag) String() string { return strconv.FormatInt(int64(*s), 10) }
+
+func (s *sizeFlag) Set(v string) error {
+	n, err := parseSize(v)
+	if err != nil {
+		return err
+	}
+	*s = sizeFlag(n)
+	return nil
+}
+
+func parseSize(v string) (int64, error) {
+	num := strings.TrimRight(strings.ToLower(v), "b")
+	mult := int64(1)
+	if len(num) > 0 {
+		switch num[len(num)-1] {
+		case 'k':
+			mult = 1000
+		case 'm':
+			mult = 1000 * 1000
+		case 'g':
+			mult = 1000 * 1000 * 1000
+		case 't':
+			mult = 1000 * 1000 * 1000 * 1000
+		}
+		if mult > 1 {
+			num = num[:len(num)-1]
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	return n * mult, nil
+}
+
+// modeFlag is a flag.Value holding file permission bits, given in octal.
+type modeFlag os.FileMode
+
+func (m *modeFlag) String() string { return fmt.Sprintf("%#o", uint32(*m)) }
+
+func (m *modeFlag) Set(v string) error {
+	n, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || n&^0777 != 0 {
+		return fmt.Errorf("invalid file mode %q", v)
+	}
+	*m = modeFlag(n)
+	return nil
+}
+
+// syncFlag is a flag.Value holding a rotator.SyncPolicy, given as never,
+// always, or a size, a duration or both separated by a comma.
+type syncFlag rotator.SyncPolicy
+
+func (s *syncFlag) String() string {
+	var when []string
+	if s.EveryWrite {
+		when = append(when, "always")
+	}
+	if s.Bytes > 0 {
+		when = append(when, strconv.FormatInt(s.Bytes, 10))
+	}
+	if s.Interval > 0 {
+		when = append(when, s.Interval.String())
+	}
+	if when == nil {
+		return "never"
+	}
+	return strings.Join(when, ",")
+}
+
+func (s *syncFlag) Set(v string) error {
+	var p rotator.SyncPolicy
+	for _, w := range strings.Split(v, ",") {
+		switch w = strings.TrimSpace(w); w {
+		case "never":
+		case "always":
+			p.EveryWrite = true
+		default:
+			if d, err := time.ParseDuration(w); err == nil && d > 0 {
+				p.Interval = d
+			} else if n, err := parseSize(w); err == nil && n > 0 {
+				p.Bytes = n
+			} else {
+				return fmt.Errorf("invalid sync policy %q: want never, always, a size or a duration", w)
+			}
+		}
+	}
+	*s = syncFlag(p)
+	return nil
+}
+
+// listFlag is a flag.Value collecting every occurrence of a repeatable flag.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// A routeArg is an argument to -route, or to -json-route if json is set.
+type routeArg struct {
+	arg  string
+	json bool
+}
+
+// routeFlag is a flag.Value collecting the arguments to -route and
+// -json-route together in the order they were given, since the first
+// matching route wins.
+type routeFlag struct {
+	list *[]routeArg
+	json bool
+}
+
+func (f routeFlag) String() string {
+	if f.list == nil {
+		return ""
+	}
+	var args []string
+	for _, a := range *f.list {
+		if a.json == f.json {
+			args = append(args, a.arg)
+		}
+	}
+	return strings.Join(args, ",")
+}
+
+func (f routeFlag) Set(v string) error {
+	*f.list = append(*f.list, routeArg{v, f.json})
+	return nil
+}
@@ -2,44 +2,754 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/moshee/logrotate/rotator"
 )
 
+// exitNoInput is the exit status when -input-timeout passes without input,
+// so that a dead producer can be told from other failures.
+const exitNoInput = 3
+
 var (
 	flagT = flag.Bool("t", false, "Behave like tee(1)")
 	flagC = flag.Int("c", 5000, "Max (uncompressed) logfile size in kB")
+
+	flagTPrimary = flag.Bool("t-primary", false, "Like -t, but treat standard output as the primary destination: exit if it can't be written")
+
+	flagSizePercent = flag.Float64("size-percent", 0, "Max logfile size as a percentage `pct` of its volume's capacity, instead of -c")
+
+	flagPIDFile = flag.String("pidfile", "", "Write the process ID to `file` while running")
+
+	flagOwner = flag.String("owner", "", "Give the logfile and archives to `user`, by name or ID (usually requires root)")
+	flagGroup = flag.String("group", "", "Give the logfile and archives to `group`, by name or ID")
+
+	flagMinFree  sizeFlag
+	flagMinSize  sizeFlag
+	flagMode     = modeFlag(0644)
+	flagTail     listFlag
+	flagCoalesce sizeFlag
+	flagZMinSize sizeFlag
+	flagZTarget  sizeFlag
+	flagFlush    sizeFlag
+	flagMaxTotal sizeFlag
+	flagSync     syncFlag
+	flagTags     listFlag
+	flagRoutes   []routeArg
+	flagFilter   listFlag
+	flagRedact   listFlag
+
+	flagIdleTimeout = flag.Duration("idle-timeout", 0, "Rotate once no input has arrived for this `duration`")
+	flagMaxAge      = flag.Duration("max-segment-age", 0, "Rotate the logfile once it has been open for this `duration`, even while input is arriving")
+	flagEvery       = flag.Duration("every", 0, "Rotate whenever the local time reaches a multiple of this `duration` since midnight (e.g. 24h at midnight, 1h on the hour)")
+	flagJitter      = flag.Duration("jitter", 0, "Delay time-triggered rotations by a random amount up to this `duration`")
+	flagJitterEach  = flag.Bool("jitter-each", false, "Choose a new -jitter delay for every rotation instead of once at startup")
+
+	flagInputTimeout = flag.Duration("input-timeout", 0, "Exit with status 3 if no input at all arrives within this `duration` of startup")
+
+	flagRotateOnStart = flag.Bool("rotate-on-start", false, "Rotate the existing logfile, if not empty, before writing to it")
+	flagRotateOnExit  = flag.Bool("rotate-on-exit", false, "Rotate the logfile, if not empty, once the input ends")
+	flagNoAppend      = flag.Bool("no-append", false, "Like -rotate-on-start, but refuse to start if the existing logfile can't be archived")
+
+	flagMaxRotations    = flag.Int("max-rotations-per-min", 0, "Pause rotation once `N` rotations have happened within a minute")
+	flagMaxRotationsErr = flag.Bool("max-rotations-exit", false, "Exit with an error instead of pausing when -max-rotations-per-min is exceeded")
+
+	flagWriteRetries    = flag.Int("write-retries", 0, "Retry writes that fail with a transient error up to `N` times")
+	flagWriteRetryDelay = flag.Duration("write-retry-delay", 10*time.Millisecond, "Delay before the first write retry, doubled for each further one")
+
+	flagWriteErrorExit = flag.Bool("exit-on-write-error", false, "Exit with an error if the logfile can't be written, instead of dropping lines until it can")
+
+	flagLineWindow = flag.Duration("line-time-window", 0, "Rotate whenever a line's own timestamp enters a new window of this `duration` (e.g. 1h)")
+	flagLineField  = flag.String("line-time-field", "", "JSON `field` holding each line's timestamp (default: the start of the line)")
+	flagLineLayout = flag.String("line-time-layout", time.RFC3339, "Go time `layout` of each line's timestamp")
+
+	flagReorder    = flag.Duration("reorder-window", 0, "Hold lines for this `duration` to write them in the order of their timestamps, found as for -line-time-window")
+	flagReorderMax = flag.Int("reorder-max-lines", 10000, "Hold at most `N` lines for -reorder-window")
+
+	flagSplitCR   = flag.Bool("split-cr", false, "Also end input lines at a lone carriage return")
+	flagDelimiter = flag.String("delimiter", "newline", "Record `delimiter`: newline, null, tab, or any single character")
+
+	flagJSONEnvelope = flag.Bool("json-envelope", false, "Wrap lines that aren't JSON objects in a JSON object with ts, host and message fields, plus any -tag fields")
+
+	flagFlushInterval = flag.Duration("flush-interval", 0, "Hold output in memory, writing it out at least this often (e.g. 1s) and whenever -flush-size is held")
+	flagFlushOnRotate = flag.Bool("flush-on-rotate-only", false, "Hold output in memory, writing and syncing it only before rotating (a crash may lose the current segment)")
+
+	flagRedactFile = flag.String("redact-file", "", "Read -redact patterns from `file`, one per line")
+
+	flagTempDir = flag.String("temp-dir", "", "Write archives in `dir` before moving them next to the logfile")
+
+	flagOversize = flag.String("oversize", "overflow", "What to do with lines longer than -c: overflow (write them whole), truncate or split")
+
+	flagMaxPending = flag.Int("max-pending-compressions", 0, "Stop reading input while `N` rotated segments are waiting to be compressed")
+
+	flagStderr     = flag.String("stderr", "", "Also read the wrapped process's standard error from `path`, a FIFO or /dev/fd/N, tagging lines [stdout] and [stderr]")
+	flagStderrFile = flag.String("stderr-file", "", "Write the -stderr lines untagged to `filename` instead, rotated independently")
+
+	flagUpload       = flag.String("upload", "", "Copy each archive into `dir`, such as where a bucket is mounted; for s3://, gs:// and the like, use -postrotate with the provider's CLI")
+	flagUploadRemove = flag.Bool("upload-remove", false, "Delete archives once -upload has copied them")
+
+	flagMetrics = flag.String("metrics", "", "Serve Prometheus metrics at /metrics on `address` (e.g. :9090)")
+
+	flagPostrotate = flag.String("postrotate", "", "Shell `command` run after each segment of any logfile is archived, with the archive's path as $1 and in $LOGROTATE_ARCHIVE")
+
+	flagDescribe = flag.Bool("describe", false, "Print the effective settings to standard error at startup")
+
+	flagRotateOn   = flag.String("rotate-on-match", "", "Rotate whenever a line matches `regexp`")
+	flagRotateOnAt = flag.String("rotate-on-match-at", "after", "Where to rotate around a -rotate-on-match line: after it, before it, or drop it")
+
+	flagLineNumbers = flag.Int("line-numbers", 0, "Prefix each line with its number within the segment, zero-padded to `width` digits")
+
+	flagOnCollision = flag.String("on-collision", "overwrite", "What to do when a segment's archive already exists: overwrite, fail (retry later) or skip (leave the segment uncompressed)")
+
+	flagCurrent = flag.String("current-suffix", "", "Write to <filename> plus `suffix` (e.g. .current), making <filename> a symlink to it")
+
+	flagCopyTruncate = flag.Bool("copytruncate", false, "Rotate by copying the logfile and truncating it in place, for when another writer holds it open by path")
+
+	flagKeepPrev = flag.Bool("keep-prev", false, "Keep the previous segment uncompressed in <filename>.prev")
+
+	flagDailyTar = flag.Bool("daily-tar", false, "Collect each day's rotated segments in one <filename>-YYYYMMDD.tar.gz")
+
+	flagRing = flag.Int("ring", 0, "Keep the last `N` segments uncompressed, overwriting the oldest on rotation")
+
+	flagAdoptReversed = flag.Bool("adopt-reversed", false, "Renumber archives left by logrotate(8), where 1 is the newest, so that higher numbers are newer")
+
+	flagCAS = flag.Bool("content-addressed", false, "Name archives after the SHA-256 hash of their content, indexed in <filename>.cas")
+
+	flagMeta         = flag.Bool("segment-meta", false, "Write each segment's line and byte counts, times and rotation reason to <segment>.meta")
+	flagTrailer      = flag.String("segment-trailer", "", "Line written at the end of every segment before rotation, using {time}, {seq}, {lines} and {bytes} (e.g. \"#END seq={seq} lines={lines}\")")
+	flagMarker       = flag.Bool("shutdown-marker", false, "Write a summary line to the logfile on clean shutdown")
+	flagMarkerFormat = flag.String("shutdown-marker-format", rotator.DefaultShutdownMarker, "`Format` of the -shutdown-marker line, using {time}, {lines}, {bytes} and {rotations}")
+
+	flagManifest = flag.Bool("manifest", false, "Maintain a JSON index of the archives in <filename>.index.json")
+
+	flagKeep       = flag.Int("keep", 0, "Keep only the newest `N` archives")
+	flagKeepDaily  = flag.Int("keep-daily", 0, "Keep only the newest archive of each day before today, and none older than `N` days")
+	flagArchiveAge = flag.Duration("max-age", 0, "Delete archives rotated more than `duration` ago (e.g. 720h)")
+	flagPruneGrace = flag.Duration("prune-grace", 0, "Never delete archives younger than `duration`")
+
+	flagNaming    = flag.String("naming", "suffix", "Rotated file naming `scheme`: suffix (app.log.1), infix (app.1.log), timestamp (app.log.2024-05-03T12-00-00.1), or a template using {name}, {base}, {ext}, {time} and {n}")
+	flagNamingSep = flag.String("naming-sep", ".", "Separator placed before the sequence number by the built-in naming schemes")
+
+	flagNamingTime = flag.String("naming-time-layout", rotator.DefaultNamingTimeLayout, "Go time `layout` of {time} in -naming")
+
+	flagZ        = flag.String("z", "gzip", "Compression `codec` for rotated files: gzip, pgzip (parallel gzip), bgzf (gzip with a .gzi index), brotli, zstd, xz, bzip2 or none")
+	flagZLevel   = flag.Int("z-level", 0, "Compression level (0 selects the codec's default)")
+	flagZBlock   sizeFlag
+	flagZExt     = flag.String("compress-ext", "", "Archive `extension` to use instead of the codec's (e.g. gz.archive)")
+	flagZTimeout = flag.Duration("compress-timeout", 0, "Leave a segment uncompressed if compressing it takes longer than `duration`")
+	flagZVerify  = flag.Bool("verify", false, "Check that each archive decompresses to its segment before removing the segment")
+	flagZKeep    = flag.Bool("keep-uncompressed", false, "Keep rotated segments next to their archives")
+	flagZHeader  = flag.Bool("z-header", false, "Record the logfile's name and the rotation time in gzip headers")
+	flagZWorkers = flag.Int("z-workers", 0, "Number of blocks pgzip compresses at once (0 means one per CPU)")
+
+	flagZRsyncable = flag.Bool("z-rsyncable", false, "Make gzip archives friendlier to rsync, like gzip --rsyncable, at a slight cost in size")
+
+	flagZstdDict  = flag.String("zstd-dict", "", "Compress zstd archives with the dictionary at `path`, which is needed to decompress them")
+	flagZstdTrain = flag.Int("zstd-train", 0, "If the -zstd-dict file doesn't exist, train it on the first `N` segments")
 )
 
 func init() {
+	flag.Var(&flagMinFree, "min-free-space", "Rotate when free space on the logfile's volume drops below `size` (e.g. 500M)")
+	flag.Var(&flagMinSize, "min-size", "Don't rotate by time unless the logfile has reached `size`")
+	flag.Var(&flagZBlock, "z-block-size", "`Size` of the blocks pgzip compresses in parallel (default 1M)")
+	flag.Var(&flagTail, "tail", "Follow `[tag=]file` instead of reading stdin, prefixing its lines with [tag] (repeatable)")
+	flag.Var(&flagZMinSize, "compress-min-size", "Leave rotated segments smaller than `size` uncompressed")
+	flag.Var(&flagZTarget, "target-compressed-size", "Adjust -c after each rotation so that archives come out close to `size`")
+	flag.Var(&flagCoalesce, "coalesce-below", "Append rotated segments to the latest archive while it is smaller than `size`")
+	flag.Var(&flagRedact, "redact", "Replace matches of `pattern` with *** in every line; email, card and token name built-in patterns (repeatable)")
+	flag.Var(routeFlag{&flagRoutes, false}, "route", "Write input lines matching `regex=>filename` to filename instead, rotated independently; the first matching route wins (repeatable)")
+	flag.Var(routeFlag{&flagRoutes, true}, "json-route", "Like -route, but for JSON lines whose field compares to a value as in `field>=value=>filename`, e.g. level>=warn=>warn.log (repeatable)")
+	flag.Var(&flagFilter, "json-filter", "Drop input lines unless they are JSON objects whose field compares to a value as in `field>=value`, using =, !=, <, <=, > or >= (repeatable)")
+	flag.Var(&flagTags, "tag", "Add `key=value` to every line; $VAR in the value is taken from the environment (repeatable)")
+	flag.Var(&flagMaxTotal, "max-total", "Delete the oldest archives while they and the logfile take up more than `size` (e.g. 10G)")
+	flag.Var(&flagSync, "sync", "Sync the logfile to disk according to `policy`: never, always (after every write), or after a size (e.g. 1M), a duration (e.g. 5s) or both (e.g. 1M,5s)")
+	flag.Var(&flagFlush, "flush-size", "Write out output held by -flush-interval once `size` is held (default 64K)")
+	flag.Var(&flagMode, "mode", "Permission `bits`, in octal, for the logfile and archives regardless of umask")
+	flag.BoolVar(flagRotateOnStart, "R", false, "Shorthand for -rotate-on-start")
+
 	log.SetFlags(0)
 	log.SetPrefix(os.Args[0] + ": ")
 
 	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, "Usage: <process that outputs to stdout> | logrotate [-t] [-c <N>] <filename>")
+		fmt.Fprintln(os.Stderr, "Usage: <process that outputs to stdout> | logrotate [options] <filename>")
+		fmt.Fprintln(os.Stderr, "       logrotate [options] list|prune <filename>")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 }
 
 func main() {
+	cmd := ""
+	if flag.Arg(0) == "list" || flag.Arg(0) == "prune" {
+		cmd = flag.Arg(0)
+		flag.CommandLine.Parse(flag.Args()[1:])
+		if flag.NArg() < 1 {
+			log.Fatalf("%s requires a filename; use ./%s to log to a file of that name", cmd, cmd)
+		}
+	}
 	if flag.NArg() < 1 {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	r, err := rotator.New(os.Stdin, flag.Arg(0), int64(*flagC), *flagT)
+	// The compressor names the archives, so list and prune need it too.
+	comp, err := compressor(*flagZ, *flagZLevel)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer r.Close()
 
-	if err := r.Run(); err != nil {
-		log.Print(err)
+	if cmd != "" {
+		err := runCommand(cmd, rotator.Config{
+			Filename:         flag.Arg(0),
+			Compressor:       comp,
+			CompressExt:      *flagZExt,
+			NoCompression:    *flagZ == "none",
+			Naming:           *flagNaming,
+			NamingSep:        *flagNamingSep,
+			NamingTimeLayout: *flagNamingTime,
+			Keep:             *flagKeep,
+			KeepDaily:        *flagKeepDaily,
+			MaxAge:           *flagArchiveAge,
+			MaxTotalSize:     int64(flagMaxTotal),
+			PruneGrace:       *flagPruneGrace,
+			Manifest:         *flagManifest,
+			ContentAddressed: *flagCAS,
+			ZstdDict:         *flagZstdDict,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
+
+	thresholdKB := int64(*flagC)
+	if *flagSizePercent > 0 {
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "c" {
+				log.Fatal("-c and -size-percent are mutually exclusive")
+			}
+		})
+		thresholdKB = 0
+	}
+
+	var in io.Reader = os.Stdin
+	var inputs []rotator.Input
+	if len(flagTail) > 0 {
+		in = nil
+		for _, arg := range flagTail {
+			input, err := tailInput(arg)
+			if err != nil {
+				log.Fatal(err)
+			}
+			inputs = append(inputs, input)
+		}
+	} else if activated := activatedInputs(); activated != nil {
+		in, inputs = nil, activated
+	}
+
+	var errIn io.Reader
+	if *flagStderr != "" {
+		if in == nil {
+			log.Fatal("-stderr requires the standard output of the process on stdin")
+		}
+		if filepath.Clean(*flagStderrFile) == filepath.Clean(flag.Arg(0)) {
+			log.Fatal("-stderr-file must differ from the logfile")
+		}
+		if *flagStderrFile != "" && *flagZstdTrain > 0 {
+			log.Fatal("-zstd-train can't be combined with -stderr-file")
+		}
+		f, err := os.Open(*flagStderr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *flagStderrFile != "" {
+			errIn = f
+		} else {
+			in, inputs = nil, []rotator.Input{
+				{Reader: os.Stdin, Prefix: "[stdout] "},
+				{Reader: f, Prefix: "[stderr] "},
+			}
+		}
+	} else if *flagStderrFile != "" {
+		log.Fatal("-stderr-file requires -stderr")
+	}
+
+	var routes []*route
+	var filters []fieldCond
+	if len(flagRoutes) > 0 || len(flagFilter) > 0 {
+		if in == nil {
+			log.Fatal("-route, -json-route and -json-filter require input on stdin")
+		}
+		if len(flagRoutes) > 0 && *flagZstdTrain > 0 {
+			log.Fatal("-zstd-train can't be combined with -route")
+		}
+		// Two Rotators writing one file would corrupt it.
+		used := map[string]bool{filepath.Clean(flag.Arg(0)): true}
+		if *flagStderrFile != "" {
+			used[filepath.Clean(*flagStderrFile)] = true
+		}
+		for _, arg := range flagRoutes {
+			rt, err := parseRoute(arg)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if used[filepath.Clean(rt.filename)] {
+				log.Fatalf("invalid route %q: %s is already written to", arg.arg, rt.filename)
+			}
+			used[filepath.Clean(rt.filename)] = true
+			routes = append(routes, rt)
+		}
+		for _, arg := range flagFilter {
+			c, err := parseCond(arg)
+			if err != nil {
+				log.Fatal(err)
+			}
+			filters = append(filters, c)
+		}
+	}
+
+	var tags []rotator.Tag
+	for _, arg := range flagTags {
+		i := strings.Index(arg, "=")
+		if i < 1 {
+			log.Fatalf("invalid tag %q: want key=value", arg)
+		}
+		tags = append(tags, rotator.Tag{Key: arg[:i], Value: os.ExpandEnv(arg[i+1:])})
+	}
+
+	redact, err := redactions(flagRedact, *flagRedactFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	oversize, err := oversizePolicy(*flagOversize)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	collision, err := collisionPolicy(*flagOnCollision)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var rotateOn *regexp.Regexp
+	if *flagRotateOn != "" {
+		rotateOn, err = regexp.Compile(*flagRotateOn)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	rotateOnAt, err := sentinelPosition(*flagRotateOnAt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var sink rotator.ArchiveSink
+	if *flagUpload != "" {
+		if strings.Contains(*flagUpload, "://") {
+			log.Fatalf("-upload to %s is not built in; use -postrotate with the provider's CLI", *flagUpload)
+		}
+		sink = rotator.DirSink(*flagUpload)
+	} else if *flagUploadRemove {
+		log.Fatal("-upload-remove requires -upload")
+	}
+
+	var marker string
+	if *flagMarker {
+		marker = *flagMarkerFormat
+	}
+
+	var split bufio.SplitFunc
+	if *flagSplitCR {
+		split = rotator.ScanLinesCR
+	}
+
+	var lineTime *rotator.LineTime
+	if *flagLineWindow > 0 {
+		lineTime = &rotator.LineTime{
+			Field:  *flagLineField,
+			Layout: *flagLineLayout,
+			Window: *flagLineWindow,
+		}
+	}
+
+	var reorder *rotator.Reorder
+	if *flagReorder > 0 {
+		reorder = &rotator.Reorder{
+			Field:    *flagLineField,
+			Layout:   *flagLineLayout,
+			Window:   *flagReorder,
+			MaxLines: *flagReorderMax,
+		}
+	}
+
+	cfg := rotator.Config{
+		Filename:      flag.Arg(0),
+		ThresholdKB:   thresholdKB,
+		Tee:           *flagT,
+		TeePrimary:    *flagTPrimary,
+		PIDFile:       *flagPIDFile,
+		MinFreeSpace:  int64(flagMinFree),
+		Naming:        *flagNaming,
+		NamingSep:     *flagNamingSep,
+		Compressor:    comp,
+		CompressExt:   *flagZExt,
+		IdleTimeout:   *flagIdleTimeout,
+		MaxSegmentAge: *flagMaxAge,
+		RotateEvery:   *flagEvery,
+		InputTimeout:  *flagInputTimeout,
+		MinSize:       int64(flagMinSize),
+		Keep:          *flagKeep,
+		KeepDaily:     *flagKeepDaily,
+		MaxAge:        *flagArchiveAge,
+		MaxTotalSize:  int64(flagMaxTotal),
+		PruneGrace:    *flagPruneGrace,
+		Mode:          os.FileMode(flagMode),
+		Owner:         *flagOwner,
+		Group:         *flagGroup,
+		RotateOnStart: *flagRotateOnStart,
+		NoAppend:      *flagNoAppend,
+		RotateOnExit:  *flagRotateOnExit,
+		Jitter:        *flagJitter,
+		JitterEach:    *flagJitterEach,
+		Inputs:        inputs,
+
+		ThresholdPercent:    *flagSizePercent,
+		MaxRotationsPerMin:  *flagMaxRotations,
+		FailOnRotationLimit: *flagMaxRotationsErr,
+		Manifest:            *flagManifest,
+		Ring:                *flagRing,
+		DailyTar:            *flagDailyTar,
+		KeepPrev:            *flagKeepPrev,
+		CurrentSuffix:       *flagCurrent,
+		Oversize:            oversize,
+		ShutdownMarker:      marker,
+		SegmentTrailer:      *flagTrailer,
+		SegmentMeta:         *flagMeta,
+		OnCollision:         collision,
+		LineNumbers:         *flagLineNumbers,
+		RotateOn:            rotateOn,
+		RotateOnAt:          rotateOnAt,
+		AdoptReversed:       *flagAdoptReversed,
+		ContentAddressed:    *flagCAS,
+		CoalesceBelow:       int64(flagCoalesce),
+		FlushOnRotate:       *flagFlushOnRotate,
+		ArchiveHeader:       *flagZHeader,
+		CompressTimeout:     *flagZTimeout,
+		CompressMinSize:     int64(flagZMinSize),
+		NoCompression:       *flagZ == "none",
+		VerifyArchives:      *flagZVerify,
+		KeepUncompressed:    *flagZKeep,
+		TempDir:             *flagTempDir,
+		Tags:                tags,
+		Redact:              redact,
+		JSONEnvelope:        *flagJSONEnvelope,
+		WriteRetries:        *flagWriteRetries,
+		WriteRetryDelay:     *flagWriteRetryDelay,
+		LineTime:            lineTime,
+		Reorder:             reorder,
+		TargetArchiveSize:   int64(flagZTarget),
+		Split:               split,
+		Delimiter:           delimiter(*flagDelimiter),
+
+		MaxPendingCompressions: *flagMaxPending,
+		NamingTimeLayout:       *flagNamingTime,
+		FailOnWriteError:       *flagWriteErrorExit,
+		CopyTruncate:           *flagCopyTruncate,
+		FlushInterval:          *flagFlushInterval,
+		FlushSize:              int(flagFlush),
+		Sync:                   rotator.SyncPolicy(flagSync),
+		Sink:                   sink,
+		RemoveUploaded:         *flagUploadRemove,
+		ZstdDict:               *flagZstdDict,
+		ZstdTrain:              *flagZstdTrain,
+	}
+	if *flagPostrotate != "" {
+		cfg.OnArchive = postrotate(*flagPostrotate)
+	}
+	// With -route, the logfile gets the lines that match no route.
+	var demuxIn io.Reader
+	var defW *io.PipeWriter
+	if routes != nil || filters != nil {
+		demuxIn = in
+		in, defW = io.Pipe()
+	}
+
+	r, err := rotator.NewWithConfig(in, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The standard error stream gets a Rotator of its own, as does each
+	// route.
+	var errR *rotator.Rotator
+	routed := make([]*rotator.Rotator, len(routes))
+
+	// closeAll closes the Rotators made so far, when giving up before
+	// running them.
+	closeAll := func() {
+		r.Close()
+		if errR != nil {
+			errR.Close()
+		}
+		for _, rr := range routed {
+			if rr != nil {
+				rr.Close()
+			}
+		}
+	}
+
+	if errIn != nil {
+		errR, err = rotator.NewWithConfig(errIn, secondaryConfig(cfg, *flagStderrFile))
+		if err != nil {
+			closeAll()
+			log.Fatal(err)
+		}
+	}
+	for i, rt := range routes {
+		routed[i], err = rotator.NewWithConfig(rt.r, secondaryConfig(cfg, rt.filename))
+		if err != nil {
+			closeAll()
+			log.Fatal(err)
+		}
+	}
+
+	if *flagDescribe {
+		fmt.Fprint(os.Stderr, r.Describe())
+		if errR != nil {
+			fmt.Fprint(os.Stderr, errR.Describe())
+		}
+		for _, rr := range routed {
+			fmt.Fprint(os.Stderr, rr.Describe())
+		}
+	}
+
+	if *flagMetrics != "" {
+		files := []logfile{{cfg.Filename, r}}
+		if errR != nil {
+			files = append(files, logfile{*flagStderrFile, errR})
+		}
+		for i, rr := range routed {
+			files = append(files, logfile{routes[i].filename, rr})
+		}
+		if err := serveMetrics(*flagMetrics, files); err != nil {
+			closeAll()
+			log.Fatal(err)
+		}
+	}
+
+	// SIGINT and SIGTERM stop reading input, after a grace period for it
+	// to end, but what has been read is still written and archived.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The other logfiles stop along with the main one, however it stops,
+	// rather than wait for input of their own that may never end.
+	others, stopOthers := context.WithCancel(ctx)
+	defer stopOthers()
+
+	errDone := make(chan error, 1)
+	if errR != nil {
+		notifyReopen(errR)
+		go func() { errDone <- errR.RunContext(others) }()
+	} else {
+		errDone <- nil
+	}
+
+	routeDone := make([]chan error, len(routed))
+	for i, rr := range routed {
+		notifyReopen(rr)
+		routeDone[i] = make(chan error, 1)
+		go func(rr *rotator.Rotator, rt *route, done chan<- error) {
+			err := rr.RunContext(others)
+			rt.w.Close()
+			done <- err
+		}(rr, routes[i], routeDone[i])
+	}
+	if demuxIn != nil {
+		delim := byte('\n')
+		if cfg.Delimiter != "" {
+			delim = cfg.Delimiter[0]
+		}
+		go demux(demuxIn, delim, filters, routes, defW)
+	}
+
+	notifyReopen(r)
+	err = r.RunContext(ctx)
+	stopOthers()
+	if defW != nil {
+		defW.Close()
+		if c, ok := demuxIn.(io.Closer); ok {
+			c.Close()
+		}
+	}
+	if errors.Is(err, context.Canceled) {
+		err = nil
+	}
+
+	// Don't let an impatient ^C cut pending compressions short.
+	signal.Ignore(os.Interrupt, syscall.SIGTERM)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	if errErr := <-errDone; errR != nil {
+		if closeErr := errR.Close(); closeErr != nil {
+			log.Printf("%s: %v", *flagStderrFile, closeErr)
+		}
+		if errErr != nil && !errors.Is(errErr, context.Canceled) {
+			log.Printf("%s: %v", *flagStderrFile, errErr)
+		}
+	}
+	for i, rr := range routed {
+		routeErr := <-routeDone[i]
+		if closeErr := rr.Close(); closeErr != nil {
+			log.Printf("%s: %v", routes[i].filename, closeErr)
+		}
+		if routeErr != nil && !errors.Is(routeErr, context.Canceled) {
+			log.Printf("%s: %v", routes[i].filename, routeErr)
+		}
+	}
+	if errors.Is(err, rotator.ErrNoInput) {
+		log.Printf("no input within %v of startup", *flagInputTimeout)
+		os.Exit(exitNoInput)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// secondaryConfig returns the Config for another logfile written by this
+// process, filename, which is like cfg but without the settings that only
+// make sense once per process.
+func secondaryConfig(cfg rotator.Config, filename string) rotator.Config {
+	cfg.Filename = filename
+	cfg.PIDFile = ""
+	cfg.Tee = false
+	cfg.TeePrimary = false
+	cfg.InputTimeout = 0
+	return cfg
+}
+
+// compressor returns the rotator.Compressor for the named codec, or nil for
+// none.
+func compressor(name string, level int) (rotator.Compressor, error) {
+	switch name {
+	case "gzip":
+		return rotator.Gzip{Level: level, Rsyncable: *flagZRsyncable}, nil
+	case "pgzip":
+		return rotator.ParallelGzip{
+			Level:     level,
+			BlockSize: int(flagZBlock),
+			Workers:   *flagZWorkers,
+		}, nil
+	case "bgzf":
+		return rotator.BGZF{Level: level}, nil
+	case "brotli":
+		return rotator.Brotli{Quality: level}, nil
+	case "zstd":
+		return rotator.Zstd{Level: level}, nil
+	case "xz":
+		return rotator.XZ{}, nil
+	case "bzip2":
+		return rotator.Bzip2{Level: level}, nil
+	case "none":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown compression codec %q", name)
+}
+
+// redactions compiles the -redact patterns, followed by those in file, if
+// any. Blank lines and lines starting with # in file are skipped.
+func redactions(patterns []string, file string) ([]*regexp.Regexp, error) {
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				patterns = append(patterns, line)
+			}
+		}
+	}
+
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		if re, ok := rotator.RedactPatterns[p]; ok {
+			res = append(res, re)
+			continue
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -redact pattern: %v", err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// oversizePolicy translates the names accepted by -oversize.
+func oversizePolicy(name string) (rotator.OversizePolicy, error) {
+	switch name {
+	case "overflow":
+		return rotator.OversizeOverflow, nil
+	case "truncate":
+		return rotator.OversizeTruncate, nil
+	case "split":
+		return rotator.OversizeSplit, nil
+	}
+	return 0, fmt.Errorf("unknown -oversize policy %q", name)
+}
+
+// collisionPolicy translates the names accepted by -on-collision.
+func collisionPolicy(name string) (rotator.CollisionPolicy, error) {
+	switch name {
+	case "overwrite":
+		return rotator.CollisionOverwrite, nil
+	case "fail":
+		return rotator.CollisionFail, nil
+	case "skip":
+		return rotator.CollisionSkip, nil
+	}
+	return 0, fmt.Errorf("unknown -on-collision policy %q", name)
+}
+
+// sentinelPosition translates the names accepted by -rotate-on-match-at.
+func sentinelPosition(name string) (rotator.SentinelPosition, error) {
+	switch name {
+	case "after":
+		return rotator.SentinelAfter, nil
+	case "before":
+		return rotator.SentinelBefore, nil
+	case "drop":
+		return rotator.SentinelDrop, nil
+	}
+	return 0, fmt.Errorf("unknown -rotate-on-match-at position %q", name)
+}
+
+// delimiter translates the names accepted by -delimiter.
+func delimiter(name string) string {
+	switch name {
+	case "newline":
+		return "\n"
+	case "null", "nul":
+		return "\x00"
+	case "tab":
+		return "\t"
+	}
+	return name
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/moshee/logrotate/rotator"
+)
+
+// A logfile is a Rotator writing the file name, for reporting.
+type logfile struct {
+	name string
+	r    *rotator.Rotator
+}
+
+// A metric describes one of the Stats exported by serveMetrics.
+type metric struct {
+	name, typ, help string
+	value           func(rotator.Stats) float64
+}
+
+var metrics = []metric{
+	{"logrotate_lines_total", "counter", "Input lines read.",
+		func(s rotator.Stats) float64 { return float64(s.Lines) }},
+	{"logrotate_written_bytes_total", "counter", "Bytes written to the logfile.",
+		func(s rotator.Stats) float64 { return float64(s.Bytes) }},
+	{"logrotate_dropped_lines_total", "counter", "Lines discarded because the logfile could not be written.",
+		func(s rotator.Stats) float64 { return float64(s.DroppedLines) }},
+	{"logrotate_dropped_bytes_total", "counter", "Bytes discarded because the logfile could not be written.",
+		func(s rotator.Stats) float64 { return float64(s.DroppedBytes) }},
+	{"logrotate_rotations_total", "counter", "Rotations performed.",
+		func(s rotator.Stats) float64 { return float64(s.Rotations) }},
+	{"logrotate_compressions_total", "counter", "Segments compressed.",
+		func(s rotator.Stats) float64 { return float64(s.Compressions) }},
+	{"logrotate_compression_seconds_total", "counter", "Time spent compressing segments.",
+		func(s rotator.Stats) float64 { return s.CompressionTime.Seconds() }},
+	{"logrotate_pending_compressions", "gauge", "Rotated segments waiting to be compressed or being compressed.",
+		func(s rotator.Stats) float64 { return float64(s.PendingCompressions) }},
+	{"logrotate_compression_retry_queue", "gauge", "Failed compressions, uploads and post-rotation commands due to be retried.",
+		func(s rotator.Stats) float64 { return float64(s.RetryQueue) }},
+	{"logrotate_file_size_bytes", "gauge", "Current size of the logfile.",
+		func(s rotator.Stats) float64 { return float64(s.Size) }},
+}
+
+// labelEscaper escapes Prometheus label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// serveMetrics starts serving the Stats of files at /metrics on addr, in the
+// Prometheus text format and labeled with the name of each file.
+func serveMetrics(addr string, files []logfile) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		stats := make([]rotator.Stats, len(files))
+		for i, f := range files {
+			stats[i] = f.r.Stats()
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, m := range metrics {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+			for i, f := range files {
+				fmt.Fprintf(w, "%s{file=\"%s\"} %s\n", m.name, labelEscaper.Replace(f.name), strconv.FormatFloat(m.value(stats[i]), 'g', -1, 64))
+			}
+		}
+	})
+	go http.Serve(ln, mux)
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+)
+
+// postrotate returns a rotator.Config.OnArchive that runs command with the
+// shell for each segment that has been rotated and archived, with the
+// archive's path as its first argument and in $LOGROTATE_ARCHIVE, and the
+// segment's original path in $LOGROTATE_SEGMENT. Commands run one at a
+// time, even for several Rotators, with their output sent to standard
+// error. A command that fails is retried by the Rotator.
+func postrotate(command string) func(ctx context.Context, segment, archive string) error {
+	var mu sync.Mutex
+	return func(ctx context.Context, segment, archive string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", command, archive)
+		} else {
+			cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command, "postrotate", archive)
+		}
+		cmd.Env = append(os.Environ(), "LOGROTATE_ARCHIVE="+archive, "LOGROTATE_SEGMENT="+segment)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+}
@@ -0,0 +1,171 @@
+package rotator
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// bgzfBlockSize is how much uncompressed data goes into each block, as
+	// chosen by bgzip so that even incompressible blocks fit in 64 KB.
+	bgzfBlockSize = 0xff00
+
+	// bgzfMaxBlock is the largest size of a compressed block.
+	bgzfMaxBlock = 1 << 16
+
+	// bgzfOverhead is the size of a block's gzip header and trailer.
+	bgzfOverhead = 18 + 8
+)
+
+// bgzfEOF is the empty block that ends every BGZF file.
+var bgzfEOF = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0, 0, 0, 0, 0, 0xff, 0x06, 0, 'B', 'C', 0x02, 0,
+	0x1b, 0, 0x03, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+}
+
+// BGZF is a Compressor producing .gz archives in the blocked gzip format
+// used by bgzip(1), together with a .gzi index of the blocks next to each
+// archive. The archives are ordinary gzip streams, readable by any gunzip,
+// but the index lets readers such as bgzip -b seek to an uncompressed offset
+// without decompressing everything before it. Compression is slightly worse
+// than Gzip, as each 64 KB block is compressed on its own.
+type BGZF struct {
+	// Level is the compression level, as for Gzip.
+	Level int
+}
+
+func (BGZF) Ext() string { return "gz" }
+
+func (BGZF) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (c BGZF) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	level := c.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+	fw, err := flate.NewWriter(nil, level)
+	if err != nil {
+		return nil, err
+	}
+	return &bgzfWriter{w: w, fw: fw}, nil
+}
+
+// bgzfWriter writes a BGZF stream, recording where each block starts.
+type bgzfWriter struct {
+	w     io.Writer
+	fw    *flate.Writer
+	buf   []byte
+	block bytes.Buffer
+	coff  uint64 // compressed offset of the next block
+	uoff  uint64 // uncompressed offset of the next block
+	index []uint64
+	err   error
+}
+
+func (z *bgzfWriter) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		m := bgzfBlockSize - len(z.buf)
+		if m > len(p) {
+			m = len(p)
+		}
+		z.buf = append(z.buf, p[:m]...)
+		p = p[m:]
+		if len(z.buf) == bgzfBlockSize {
+			if err := z.writeBlock(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close writes any buffered data and the end of file marker.
+func (z *bgzfWriter) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if len(z.buf) > 0 {
+		if err := z.writeBlock(); err != nil {
+			return err
+		}
+	}
+	_, z.err = z.w.Write(bgzfEOF)
+	return z.err
+}
+
+// writeBlock compresses the buffered data into a block of its own.
+func (z *bgzfWriter) writeBlock() error {
+	data := z.buf
+	z.deflate(data, z.fw)
+	if z.err == nil && z.block.Len()+bgzfOverhead > bgzfMaxBlock {
+		// Data that deflate can't shrink is stored as it is instead.
+		fw, _ := flate.NewWriter(nil, flate.NoCompression)
+		z.deflate(data, fw)
+	}
+	if z.err != nil {
+		return z.err
+	}
+
+	size := z.block.Len() + bgzfOverhead
+	var hdr [18]byte
+	copy(hdr[:], bgzfEOF[:16])
+	binary.LittleEndian.PutUint16(hdr[16:], uint16(size-1))
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:], crc32.ChecksumIEEE(data))
+	binary.LittleEndian.PutUint32(trailer[4:], uint32(len(data)))
+
+	for _, b := range [][]byte{hdr[:], z.block.Bytes(), trailer[:]} {
+		if _, z.err = z.w.Write(b); z.err != nil {
+			return z.err
+		}
+	}
+
+	// The index lists the start of every block but the first.
+	if z.coff > 0 {
+		z.index = append(z.index, z.coff, z.uoff)
+	}
+	z.coff += uint64(size)
+	z.uoff += uint64(len(data))
+	z.buf = z.buf[:0]
+	return nil
+}
+
+// deflate compresses data into z.block with fw.
+func (z *bgzfWriter) deflate(data []byte, fw *flate.Writer) {
+	z.block.Reset()
+	fw.Reset(&z.block)
+	if _, err := fw.Write(data); err != nil {
+		z.err = err
+		return
+	}
+	z.err = fw.Close()
+}
+
+// Index returns the stream's index in the .gzi format of bgzip: the number
+// of entries followed by the compressed and uncompressed offsets of each
+// block but the first, all as little-endian 64-bit integers.
+func (z *bgzfWriter) Index() ([]byte, error) {
+	if z.err != nil {
+		return nil, z.err
+	}
+	if len(z.buf) > 0 {
+		return nil, errors.New("bgzf: index requested before close")
+	}
+	b := make([]byte, 8*(1+len(z.index)))
+	binary.LittleEndian.PutUint64(b, uint64(len(z.index)/2))
+	for i, off := range z.index {
+		binary.LittleEndian.PutUint64(b[8*(i+1):], off)
+	}
+	return b, nil
+}
@@ -0,0 +1,184 @@
+package rotator
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// casPrefix introduces the hash in the names of content-addressed archives.
+// It keeps them apart from sequence numbers when names are parsed.
+const casPrefix = "sha256-"
+
+// casIndexName returns the path of the index of content-addressed archives
+// for filename.
+func casIndexName(filename string) string {
+	return filename + ".cas"
+}
+
+// A casEntry maps a sequence number to a content-addressed archive. The
+// index holds one entry per line, as the sequence number, the time of the
+// rotation in RFC 3339 format and the archive's base name, separated by
+// tabs.
+type casEntry struct {
+	seq     int
+	rotated time.Time
+	name    string
+}
+
+// readCASIndex returns the entries of the index at name, which may not
+// exist yet.
+func readCASIndex(fsys FS, name string) ([]casEntry, error) {
+	f, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []casEntry
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		seq, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		rotated, _ := time.Parse(time.RFC3339, fields[1])
+		entries = append(entries, casEntry{seq, rotated, fields[2]})
+	}
+	return entries, s.Err()
+}
+
+// casName returns the path of the archive whose content has the SHA-256
+// hash sum.
+func (nm *namer) casName(sum []byte, ext string) string {
+	return filepath.Join(nm.dir, nm.prefix+casPrefix+hex.EncodeToString(sum[:8])+nm.suffix+"."+ext)
+}
+
+// address moves the archive arcname of the segment described by job to its
+// content-addressed name, given the hash sum of the segment, after
+// recording it in the index, so that a crash can't leave an archive that
+// numbering and retention don't know of. An archive of an identical segment
+// may already be there, in which case the two segments share it and the new
+// archive is removed. As names hold only part of the hash, such an archive
+// is first checked by decompressing it with comp; if its content differs,
+// or can't be checked, the new archive keeps its numbered name.
+func (r *Rotator) address(arcname string, job compressJob, sum []byte, comp Compressor) (string, error) {
+	name := r.naming.casName(sum, r.ext)
+
+	r.archiveMu.Lock()
+	defer r.archiveMu.Unlock()
+	_, err := r.fs.Stat(name)
+	exists := err == nil
+	if exists {
+		if err := sameContent(r.fs, name, sum, comp); err != nil {
+			r.logf("leaving %s under its own name: %v", arcname, err)
+			return arcname, nil
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	f, err := openFile(r.fs, r.naming.index, os.O_CREATE|os.O_APPEND|os.O_WRONLY, r.mode)
+	if err != nil {
+		return "", err
+	}
+	_, err = fmt.Fprintf(f, "%d\t%s\t%s\n", job.seq, job.rotated.Format(time.RFC3339), filepath.Base(name))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if exists {
+		r.fs.Remove(arcname)
+		r.fs.Remove(arcname + ".gzi")
+		return name, nil
+	}
+	if err := r.fs.Rename(arcname, name); err != nil {
+		return "", err
+	}
+	if err := r.fs.Rename(arcname+".gzi", name+".gzi"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	return name, nil
+}
+
+// sameContent checks that the archive name, compressed with c, decompresses
+// to content with the SHA-256 hash sum.
+func sameContent(fsys FS, name string, sum []byte, c Compressor) error {
+	d, ok := c.(Decompressor)
+	if !ok {
+		return fmt.Errorf("%s archives cannot be checked against %s", c.Ext(), name)
+	}
+	f, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	z, err := d.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("checking %s: %w", name, err)
+	}
+	defer z.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, z); err != nil {
+		return fmt.Errorf("checking %s: %w", name, err)
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return fmt.Errorf("%s holds different content under the same truncated hash", name)
+	}
+	return nil
+}
+
+// pruneCASIndex rewrites the index without the entries whose archives no
+// longer exist, apart from the newest, which numbering goes by. The caller
+// must hold r.archiveMu.
+func (r *Rotator) pruneCASIndex() error {
+	entries, err := readCASIndex(r.fs, r.naming.index)
+	if err != nil {
+		return err
+	}
+	newest := 0
+	for _, c := range entries {
+		if c.seq > newest {
+			newest = c.seq
+		}
+	}
+
+	var b bytes.Buffer
+	gone := make(map[string]bool)
+	dropped := false
+	for _, c := range entries {
+		if _, ok := gone[c.name]; !ok {
+			_, err := r.fs.Stat(filepath.Join(r.naming.dir, c.name))
+			gone[c.name] = errors.Is(err, fs.ErrNotExist)
+		}
+		if gone[c.name] && c.seq != newest {
+			dropped = true
+			continue
+		}
+		fmt.Fprintf(&b, "%d\t%s\t%s\n", c.seq, c.rotated.Format(time.RFC3339), c.name)
+	}
+	if !dropped {
+		return nil
+	}
+	return writeFileAtomic(r.fs, r.naming.index, b.Bytes(), r.mode)
+}
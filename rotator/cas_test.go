@@ -0,0 +1,123 @@
+package rotator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// casRotator returns a content-addressed Rotator writing /logs/app.log in m.
+func casRotator(t *testing.T, m *memFS, keep int) *Rotator {
+	t.Helper()
+	r, err := NewWithConfig(nil, Config{Filename: "/logs/app.log", ThresholdKB: 1, ContentAddressed: true, Keep: keep, FS: m, ErrorLog: log.New(io.Discard, "", 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// writeSegments writes the segments to r, rotating after each, and closes
+// it.
+func writeSegments(t *testing.T, r *Rotator, segments ...string) {
+	t.Helper()
+	for _, seg := range append(segments, "") {
+		// A requested rotation happens at the next Write.
+		if _, err := r.Write([]byte(seg)); err != nil {
+			t.Fatal(err)
+		}
+		r.Rotate()
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+}
+
+func casBase(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "app.log." + casPrefix + hex.EncodeToString(sum[:8]) + ".gz"
+}
+
+func TestContentAddressed(t *testing.T) {
+	seg := strings.Repeat("x", 99) + "\n"
+
+	t.Run("Shared", func(t *testing.T) {
+		m := newMemFS("/logs")
+		writeSegments(t, casRotator(t, m, 0), seg, seg, seg)
+		want := []string{"app.log", "app.log.cas", casBase(seg)}
+		if got := m.names("/logs"); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Fatalf("got files %v, want %v", got, want)
+		}
+		if got := gunzip(t, m.read(t, "/logs/"+casBase(seg))); string(got) != seg {
+			t.Errorf("archive holds %q, want %q", got, seg)
+		}
+		if n := bytes.Count(m.read(t, "/logs/app.log.cas"), []byte("\n")); n != 3 {
+			t.Errorf("index has %d entries, want 3", n)
+		}
+	})
+
+	t.Run("Meta", func(t *testing.T) {
+		m := newMemFS("/logs")
+		r, err := NewWithConfig(nil, Config{Filename: "/logs/app.log", ThresholdKB: 1, ContentAddressed: true, SegmentMeta: true, FS: m})
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeSegments(t, r, seg, seg)
+		arcs, err := r.Archives()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The shared archive carries the metadata of both segments, and
+		// neither is taken for an archive of its own.
+		if len(arcs) != 1 || len(arcs[0].Files) != 3 {
+			t.Errorf("got archives %+v, want one with its archive and 2 metadata files", arcs)
+		}
+	})
+
+	t.Run("Collision", func(t *testing.T) {
+		m := newMemFS("/logs")
+		var other bytes.Buffer
+		z := gzip.NewWriter(&other)
+		z.Write([]byte("something else\n"))
+		z.Close()
+		m.write("/logs/"+casBase(seg), other.Bytes())
+
+		writeSegments(t, casRotator(t, m, 0), seg)
+		if got := m.read(t, "/logs/"+casBase(seg)); !bytes.Equal(got, other.Bytes()) {
+			t.Error("existing archive was replaced")
+		}
+		if got := gunzip(t, m.read(t, "/logs/app.log.1.gz")); string(got) != seg {
+			t.Errorf("numbered archive holds %q, want %q", got, seg)
+		}
+		want := []string{"app.log", "app.log.1.gz", casBase(seg)}
+		if got := m.names("/logs"); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("got files %v, want %v", got, want)
+		}
+	})
+
+	t.Run("Prune", func(t *testing.T) {
+		m := newMemFS("/logs")
+		var segs []string
+		for i := 0; i < 4; i++ {
+			segs = append(segs, fmt.Sprintf("%d %s", i, seg))
+		}
+		writeSegments(t, casRotator(t, m, 2), segs...)
+		want := []string{"app.log", "app.log.cas", casBase(segs[2]), casBase(segs[3])}
+		sort.Strings(want)
+		if got := m.names("/logs"); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("got files %v, want %v", got, want)
+		}
+		index := string(m.read(t, "/logs/app.log.cas"))
+		if strings.Count(index, "\n") != 2 || !strings.Contains(index, casBase(segs[2])) || !strings.Contains(index, casBase(segs[3])) {
+			t.Errorf("index holds %q, want the 2 kept archives only", index)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package rotator
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCloseTwice(t *testing.T) {
+	r, err := NewWithConfig(nil, Config{Filename: filepath.Join(t.TempDir(), "app.log"), ThresholdKB: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("line\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("second Close: got %v, want os.ErrClosed", err)
+	}
+	if _, err := r.Write([]byte("late\n")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Write after Close: got %v, want os.ErrClosed", err)
+	}
+	for range r.Events() {
+	}
+}
+
+// stallFS is an FS whose files block every read until release is closed,
+// like files on a stalled disk.
+type stallFS struct {
+	FS
+	release chan struct{}
+}
+
+func (s stallFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := s.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return stallFile{f, s.release}, nil
+}
+
+type stallFile struct {
+	File
+	release chan struct{}
+}
+
+func (f stallFile) Read(p []byte) (int, error) {
+	<-f.release
+	return f.File.Read(p)
+}
+
+func TestCloseCompressTimeout(t *testing.T) {
+	fsys := stallFS{newMemFS("/logs"), make(chan struct{})}
+	defer close(fsys.release)
+	r, err := NewWithConfig(nil, Config{Filename: "/logs/app.log", ThresholdKB: 1, CompressTimeout: 10 * time.Millisecond, FS: fsys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat("x", 99) + "\n"
+	for i := 0; i < 11; i++ {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now()
+	err = r.Close()
+	if err == nil || !strings.Contains(err.Error(), "[1]") {
+		t.Errorf("Close: got %v, want an error naming segment 1", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Close took %v", d)
+	}
+}
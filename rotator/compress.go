@@ -0,0 +1,541 @@
+package rotator
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/pgzip"
+	"github.com/ulikunitz/xz"
+)
+
+// A Compressor produces the compressed archives of rotated segments.
+type Compressor interface {
+	// Ext returns the extension given to archives, without the leading dot.
+	Ext() string
+
+	// NewWriter returns a WriteCloser that compresses everything written to
+	// it into w. Closing it flushes any buffered data but does not close w.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+// An AppendableCompressor is a Compressor whose archives can be appended
+// to: concatenating two of its compressed streams yields a stream that
+// decompresses to the concatenation of their contents, as with gzip members.
+type AppendableCompressor interface {
+	Compressor
+	CanAppend() bool
+}
+
+// A Decompressor is a Compressor that can also read its archives back.
+type Decompressor interface {
+	Compressor
+
+	// NewReader returns a ReadCloser that decompresses r. Closing it does
+	// not close r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// A HeaderCompressor is a Compressor whose archives can record the name and
+// modification time of the original file.
+type HeaderCompressor interface {
+	Compressor
+
+	// NewWriterHeader is like NewWriter, but records name and modTime in
+	// the archive.
+	NewWriterHeader(w io.Writer, name string, modTime time.Time) (io.WriteCloser, error)
+}
+
+// Gzip is a Compressor producing .gz archives.
+type Gzip struct {
+	// Level is the compression level, from gzip.BestSpeed to
+	// gzip.BestCompression. Zero selects the default level.
+	Level int
+
+	// Rsyncable makes archives friendlier to delta-transfer tools such as
+	// rsync, like gzip --rsyncable: the input is compressed in chunks whose
+	// boundaries depend on its content, so that similar segments produce
+	// archives with long runs of identical bytes. Archives are slightly
+	// larger.
+	Rsyncable bool
+}
+
+func (Gzip) Ext() string { return "gz" }
+
+func (Gzip) CanAppend() bool { return true }
+
+func (c Gzip) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return c.NewWriterHeader(w, "", time.Time{})
+}
+
+func (c Gzip) NewWriterHeader(w io.Writer, name string, modTime time.Time) (io.WriteCloser, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if c.Rsyncable {
+		return newRsyncWriter(w, level, name, modTime)
+	}
+	z, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+	z.Name, z.ModTime = name, modTime
+	return z, nil
+}
+
+func (Gzip) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// ParallelGzip is a Compressor producing .gz archives like Gzip, but splits
+// its input into blocks that are compressed concurrently. The output is a
+// standard gzip stream, readable by any gunzip. It is much faster than Gzip
+// for large segments on multi-core machines.
+type ParallelGzip struct {
+	// Level is the compression level, as for Gzip.
+	Level int
+
+	// BlockSize is the size in bytes of the blocks compressed in parallel.
+	// Zero selects 1 MB.
+	BlockSize int
+
+	// Workers is the number of blocks compressed at once. Zero selects
+	// runtime.GOMAXPROCS(0).
+	Workers int
+}
+
+func (ParallelGzip) Ext() string { return "gz" }
+
+func (ParallelGzip) CanAppend() bool { return true }
+
+func (c ParallelGzip) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return c.NewWriterHeader(w, "", time.Time{})
+}
+
+func (c ParallelGzip) NewWriterHeader(w io.Writer, name string, modTime time.Time) (io.WriteCloser, error) {
+	level := c.Level
+	if level == 0 {
+		level = pgzip.DefaultCompression
+	}
+	z, err := pgzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+
+	blockSize, workers := c.BlockSize, c.Workers
+	if blockSize == 0 {
+		blockSize = 1 << 20
+	}
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if err := z.SetConcurrency(blockSize, workers); err != nil {
+		return nil, err
+	}
+	z.Name, z.ModTime = name, modTime
+	return z, nil
+}
+
+func (ParallelGzip) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return pgzip.NewReader(r)
+}
+
+// Brotli is a Compressor producing .br archives. It is considerably slower
+// than Gzip but compresses text much better at high quality levels, which
+// makes it a good fit for archives that are rarely read.
+type Brotli struct {
+	// Quality is the compression quality, from 1, the fastest, to
+	// brotli.BestCompression (11). Zero selects the default quality, so
+	// brotli's own quality 0 can't be chosen.
+	Quality int
+}
+
+func (Brotli) Ext() string { return "br" }
+
+func (c Brotli) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	if c.Quality == 0 {
+		return brotli.NewWriter(w), nil
+	}
+	return brotli.NewWriterLevel(w, c.Quality), nil
+}
+
+func (Brotli) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(brotli.NewReader(r)), nil
+}
+
+// XZ is a Compressor producing .xz archives. It is slow, but compresses
+// about as well as Brotli and is readable by the xz tools found everywhere.
+type XZ struct{}
+
+func (XZ) Ext() string { return "xz" }
+
+func (XZ) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return xz.NewWriter(w)
+}
+
+func (XZ) NewReader(r io.Reader) (io.ReadCloser, error) {
+	z, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(z), nil
+}
+
+// Bzip2 is a Compressor producing .bz2 archives, for consumers that expect
+// them.
+type Bzip2 struct {
+	// Level is the compression level, from bzip2.BestSpeed to
+	// bzip2.BestCompression. Zero selects the default level.
+	Level int
+}
+
+func (Bzip2) Ext() string { return "bz2" }
+
+func (c Bzip2) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return bzip2.NewWriter(w, &bzip2.WriterConfig{Level: c.Level})
+}
+
+func (Bzip2) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return bzip2.NewReader(r, nil)
+}
+
+// Compress compresses the file src into the new file dst with c, or with
+// Gzip if c is nil, exactly as the Rotator compresses rotated segments. dst
+// gets the permissions of src; any index the compressor produces is written
+// next to it with ".gzi" appended. dst is removed if compression fails.
+func Compress(src, dst string, c Compressor) error {
+	if c == nil {
+		c = Gzip{}
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	opts := archiveOptions{fs: osFS{}, comp: c, mode: info.Mode().Perm()}
+	index, err := compressNew(in, dst, opts)
+	if err == nil && index != nil {
+		err = writeIndex(dst+".gzi", index, opts)
+	}
+	if err != nil && !errors.Is(err, fs.ErrExist) {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// Decompress decompresses the archive src into the new file dst with c, or
+// with Gzip if c is nil, which must be a Decompressor. dst gets the
+// permissions of src, and is removed if decompression fails.
+func Decompress(src, dst string, c Compressor) error {
+	if c == nil {
+		c = Gzip{}
+	}
+	d, ok := c.(Decompressor)
+	if !ok {
+		return fmt.Errorf("%s archives cannot be decompressed", c.Ext())
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := openFile(osFS{}, dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	z, err := d.NewReader(in)
+	if err == nil {
+		_, err = io.Copy(out, z)
+		if cerr := z.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// An indexer is a compressing writer that can describe the stream it
+// wrote, once closed, in an index to be kept next to the archive.
+type indexer interface {
+	Index() ([]byte, error)
+}
+
+// canAppend reports whether c's archives can be appended to.
+func canAppend(c Compressor) bool {
+	a, ok := c.(AppendableCompressor)
+	return ok && a.CanAppend()
+}
+
+// archiveOptions controls how compress writes archives.
+type archiveOptions struct {
+	fs   FS
+	comp Compressor
+	ext  string // extension of the archive, without the dot
+	mode os.FileMode
+
+	// tempDir, if set, is where archives are written before being moved
+	// into place.
+	tempDir string
+
+	// name and modTime, if name is set, are recorded in the archive by
+	// compressors that support it.
+	name    string
+	modTime time.Time
+
+	// hash, if set, is fed the content of the segment as it is compressed.
+	hash hash.Hash
+}
+
+// compress writes the compressed contents of src to name plus the archive
+// extension. If appending is set, the compressed stream is
+// added to the end of an existing archive instead; should that fail, the
+// archive is truncated back to its former size. If the compressor produces
+// an index, it is written next to the archive with ".gzi" appended.
+// Compression is abandoned once ctx is done.
+func compress(ctx context.Context, src io.Reader, name string, appending bool, opts archiveOptions) error {
+	src = ctxReader{ctx, src}
+	arcname := name + "." + opts.ext
+	if appending {
+		return appendArchive(src, arcname, opts)
+	}
+
+	var index []byte
+	var err error
+	if opts.tempDir != "" {
+		index, err = compressVia(src, arcname, opts)
+	} else {
+		index, err = compressNew(src, arcname, opts)
+	}
+	if err != nil || index == nil {
+		return err
+	}
+	return writeIndex(arcname+".gzi", index, opts)
+}
+
+// compressNew writes the compressed contents of src to the new file arcname,
+// which is removed again if this fails.
+func compressNew(src io.Reader, arcname string, opts archiveOptions) ([]byte, error) {
+	arc, err := openFile(opts.fs, arcname, os.O_CREATE|os.O_EXCL|os.O_WRONLY, opts.mode)
+	if err != nil {
+		return nil, err
+	}
+	index, err := compressTo(arc, src, opts)
+	if err != nil {
+		arc.Close()
+		opts.fs.Remove(arcname)
+		return nil, err
+	}
+	return index, arc.Close()
+}
+
+// writeIndex writes an archive's index to name.
+func writeIndex(name string, index []byte, opts archiveOptions) error {
+	f, err := openFile(opts.fs, name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, opts.mode)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(index); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// appendArchive appends the compressed contents of src to arcname.
+func appendArchive(src io.Reader, arcname string, opts archiveOptions) (err error) {
+	arc, err := openFile(opts.fs, arcname, os.O_APPEND|os.O_WRONLY, opts.mode)
+	if err != nil {
+		return err
+	}
+	info, err := arc.Stat()
+	if err != nil {
+		arc.Close()
+		return err
+	}
+
+	if _, err := compressTo(arc, src, opts); err != nil {
+		arc.Truncate(info.Size())
+		arc.Close()
+		return err
+	}
+	return arc.Close()
+}
+
+// compressVia compresses src into a private temporary file in opts.tempDir
+// and then moves it to arcname. The temporary file is removed whether or
+// not this succeeds.
+func compressVia(src io.Reader, arcname string, opts archiveOptions) ([]byte, error) {
+	if _, err := opts.fs.Stat(arcname); err == nil {
+		return nil, &os.PathError{Op: "compress", Path: arcname, Err: os.ErrExist}
+	}
+
+	tmp, err := createTemp(opts.fs, opts.tempDir, filepath.Base(arcname)+".")
+	if err != nil {
+		return nil, err
+	}
+	defer opts.fs.Remove(tmp.Name())
+
+	index, err := compressTo(tmp, src, opts)
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Chmod(opts.mode); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.fs.Rename(tmp.Name(), arcname); err == nil {
+		return index, nil
+	}
+
+	// The temporary directory may be on another volume.
+	tmp, err = opts.fs.OpenFile(tmp.Name(), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer tmp.Close()
+	arc, err := openFile(opts.fs, arcname, os.O_CREATE|os.O_EXCL|os.O_WRONLY, opts.mode)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(arc, tmp); err != nil {
+		arc.Close()
+		opts.fs.Remove(arcname)
+		return nil, err
+	}
+	return index, arc.Close()
+}
+
+// A CollisionPolicy selects what happens when the archive a rotated segment
+// is to be compressed into already exists, such as after a crash midway
+// through compressing it.
+type CollisionPolicy int
+
+const (
+	// CollisionOverwrite deletes the existing archive and compresses the
+	// segment as usual. This is the default: sequence numbers belong to the
+	// Rotator, so the existing archive is almost certainly a leftover.
+	CollisionOverwrite CollisionPolicy = iota
+
+	// CollisionFail fails the compression, leaving the segment and the
+	// archive as they are. The compression is retried later like any other
+	// failed one.
+	CollisionFail
+
+	// CollisionSkip leaves the segment uncompressed next to the existing
+	// archive, and logs it.
+	CollisionSkip
+)
+
+// verifyArchive checks that the archive arcname decompresses to the same
+// content as src, going by size and CRC-32 checksum.
+func verifyArchive(fsys FS, arcname string, src io.ReadSeeker, c Compressor) error {
+	d, ok := c.(Decompressor)
+	if !ok {
+		return fmt.Errorf("%s archives cannot be verified", c.Ext())
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	want := crc32.NewIEEE()
+	wantN, err := io.Copy(want, src)
+	if err != nil {
+		return err
+	}
+
+	arc, err := fsys.OpenFile(arcname, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer arc.Close()
+	z, err := d.NewReader(arc)
+	if err != nil {
+		return fmt.Errorf("verifying %s: %w", arcname, err)
+	}
+	defer z.Close()
+	got := crc32.NewIEEE()
+	gotN, err := io.Copy(got, z)
+	if err != nil {
+		return fmt.Errorf("verifying %s: %w", arcname, err)
+	}
+
+	if gotN != wantN || got.Sum32() != want.Sum32() {
+		return fmt.Errorf("verifying %s: content differs from the segment", arcname)
+	}
+	return nil
+}
+
+// ctxReader is a Reader that fails once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// compressTo writes the compressed contents of src to w. If the
+// compressor's writer keeps an index, it is returned as well.
+func compressTo(w io.Writer, src io.Reader, opts archiveOptions) ([]byte, error) {
+	if opts.hash != nil {
+		src = io.TeeReader(src, opts.hash)
+	}
+	var z io.WriteCloser
+	var err error
+	if hc, ok := opts.comp.(HeaderCompressor); ok && opts.name != "" {
+		z, err = hc.NewWriterHeader(w, opts.name, opts.modTime)
+	} else {
+		z, err = opts.comp.NewWriter(w)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(z, src); err != nil {
+		z.Close()
+		return nil, err
+	}
+	if err := z.Close(); err != nil {
+		return nil, err
+	}
+	if ix, ok := z.(indexer); ok {
+		return ix.Index()
+	}
+	return nil, nil
+}
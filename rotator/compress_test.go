@@ -0,0 +1,140 @@
+package rotator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCollision(t *testing.T) {
+	line := strings.Repeat("x", 99) + "\n"
+	segment := strings.Repeat(line, 10)
+	tests := []struct {
+		policy       CollisionPolicy
+		keepsSegment bool
+		fails        bool
+		logs         string
+	}{
+		{CollisionOverwrite, false, false, ""},
+		{CollisionFail, true, true, ""},
+		{CollisionSkip, true, false, "already exists"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			m := newMemFS("/logs")
+			// Leave a stale archive behind for the segment about to be
+			// rotated, as a crash midway through compressing it would.
+			stale := func(op, name string) error {
+				if op == "rename" && filepath.Base(name) == "app.log" {
+					m.write("/logs/app.log.1.gz", []byte("stale"))
+				}
+				return nil
+			}
+			var errs bytes.Buffer
+			r, err := NewWithConfig(nil, Config{
+				Filename:    "/logs/app.log",
+				ThresholdKB: 1,
+				OnCollision: tt.policy,
+				FS:          withFaults(m, stale),
+				ErrorLog:    log.New(&errs, "", 0),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 11; i++ {
+				if _, err := r.Write([]byte(line)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+			failed := false
+			for e := range r.Events() {
+				if e.Type == CompressionFailed && errors.Is(e.Err, fs.ErrExist) {
+					failed = true
+				}
+			}
+			if failed != tt.fails {
+				t.Errorf("compression failed: %v, want %v", failed, tt.fails)
+			}
+
+			arc := m.read(t, "/logs/app.log.1.gz")
+			if tt.keepsSegment {
+				if got := string(m.read(t, "/logs/app.log.1")); got != segment {
+					t.Errorf("segment holds %d bytes, want %d", len(got), len(segment))
+				}
+				if string(arc) != "stale" {
+					t.Errorf("stale archive was replaced")
+				}
+			} else {
+				if got := string(gunzip(t, arc)); got != segment {
+					t.Errorf("archive holds %d bytes, want %d", len(got), len(segment))
+				}
+				if names := m.names("/logs"); strings.Join(names, " ") != "app.log app.log.1.gz" {
+					t.Errorf("got files %v, want the logfile and the archive", names)
+				}
+			}
+			if !strings.Contains(errs.String(), tt.logs) || tt.logs == "" && errs.Len() > 0 {
+				t.Errorf("logged %q, want %q", errs.String(), tt.logs)
+			}
+		})
+	}
+}
+
+// TestCompressionOrder rotates back to back, with every line different, and
+// checks that the archives come out in rotation order, each once.
+func TestCompressionOrder(t *testing.T) {
+	m := newMemFS("/logs")
+	r, err := NewWithConfig(nil, Config{Filename: "/logs/app.log", ThresholdKB: 1, FS: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var completed []int // sequence numbers; events may be dropped
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range r.Events() {
+			switch e.Type {
+			case RotationCompleted:
+				n, _ := r.naming.parse(filepath.Base(e.Archive))
+				completed = append(completed, n)
+			case CompressionFailed:
+				t.Errorf("compressing %s: %v", e.Segment, e.Err)
+			}
+		}
+	}()
+
+	var want bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		line := fmt.Sprintf("%099d\n", i)
+		want.WriteString(line)
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	var got []byte
+	n := len(m.names("/logs")) - 1
+	for i := 1; i <= n; i++ {
+		got = append(got, gunzip(t, m.read(t, fmt.Sprintf("/logs/app.log.%d.gz", i)))...)
+	}
+	got = append(got, m.read(t, "/logs/app.log")...)
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("archives and logfile don't hold the lines in the order written; files: %v", m.names("/logs"))
+	}
+	for i := 1; i < len(completed); i++ {
+		if completed[i] <= completed[i-1] {
+			t.Fatalf("archives completed out of order: %v", completed)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package rotator
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// A SymlinkFS is an FS that supports symbolic links, as required by
+// Config.CurrentSuffix. The operating system's file system implements it.
+type SymlinkFS interface {
+	FS
+	Symlink(oldname, newname string) error
+	Readlink(name string) (string, error)
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+func (osFS) Symlink(oldname, newname string) error  { return os.Symlink(oldname, newname) }
+func (osFS) Readlink(name string) (string, error)   { return os.Readlink(name) }
+func (osFS) Lstat(name string) (fs.FileInfo, error) { return os.Lstat(name) }
+
+// linkCurrent makes filename a symbolic link to live, the file actually
+// written to. A regular file at filename, left over from running without a
+// current suffix, becomes the live file if there is none yet.
+func linkCurrent(fsys FS, filename, live string) error {
+	sfs, ok := fsys.(SymlinkFS)
+	if !ok {
+		return errors.New("a current suffix requires a file system with symlinks")
+	}
+
+	target := filepath.Base(live)
+	info, err := sfs.Lstat(filename)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	case info.Mode()&fs.ModeSymlink != 0:
+		if t, err := sfs.Readlink(filename); err == nil && t == target {
+			return nil
+		}
+	case info.Mode().IsRegular():
+		if _, err := fsys.Stat(live); err == nil {
+			return fmt.Errorf("both %s and %s exist", filename, live)
+		}
+		if err := fsys.Rename(filename, live); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%s is neither a regular file nor a symlink", filename)
+	}
+
+	// Replace whatever is there atomically.
+	tmp := filename + ".link"
+	fsys.Remove(tmp)
+	if err := sfs.Symlink(target, tmp); err != nil {
+		return err
+	}
+	return fsys.Rename(tmp, filename)
+}
@@ -0,0 +1,264 @@
+package rotator
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Describe returns a human-readable summary of the Rotator's effective
+// settings, one per line, for logging at startup. Settings left at their
+// defaults that don't change behavior are omitted.
+func (r *Rotator) Describe() string {
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\n", args...)
+	}
+
+	if r.live != r.filename {
+		line("logfile: %s (writing to %s)", r.filename, r.live)
+	} else {
+		line("logfile: %s", r.filename)
+	}
+	line("mode: %#o", r.mode)
+	if r.owner != "" || r.group != "" {
+		line("owner: %s, group: %s", valueOr(r.owner, "unchanged"), valueOr(r.group, "unchanged"))
+	}
+	if r.tee {
+		line("tee: stdout (primary: %t)", r.teePrimary)
+	}
+	if r.flushInt > 0 {
+		line("write: held in memory for up to %v or %d bytes (synced on rotation: %t)", r.flushInt, r.holdMax, r.durable)
+	} else if r.durable {
+		line("write: held in memory until rotation")
+	}
+	if r.syncPol != (SyncPolicy{}) {
+		var when []string
+		if r.syncPol.EveryWrite {
+			when = append(when, "every write")
+		}
+		if r.syncPol.Bytes > 0 {
+			when = append(when, fmt.Sprintf("every %d bytes", r.syncPol.Bytes))
+		}
+		if r.syncPol.Interval > 0 {
+			when = append(when, fmt.Sprintf("every %v", r.syncPol.Interval))
+		}
+		line("sync: %s", strings.Join(when, ", "))
+	}
+	if r.policy != WriteBlock {
+		line("write policy: %v", r.policy)
+	}
+	if r.delim != '\n' {
+		line("delimiter: %q", r.delim)
+	}
+	if r.redact != nil {
+		line("redact: true")
+	}
+	if r.tags != nil {
+		line("tags: %q (JSON envelope: %t)", r.tags.prefix, r.tags.envelope)
+	}
+	if r.numWidth > 0 {
+		line("line numbers: %d digits", r.numWidth)
+	}
+	if r.meta {
+		line("segment metadata: true")
+	}
+	if r.trailer != "" {
+		line("segment trailer: %q", r.trailer)
+	}
+	if r.marker != "" {
+		line("shutdown marker: %q", r.marker)
+	}
+
+	if r.sizePct > 0 {
+		line("rotate at: %d bytes (%g%% of the volume)", r.threshold, r.sizePct)
+	} else {
+		line("rotate at: %d bytes", r.threshold)
+	}
+	if r.zTarget > 0 {
+		line("target archive size: %d bytes", r.zTarget)
+	}
+	if r.oversize != OversizeOverflow {
+		line("oversize lines: %v", r.oversize)
+	}
+	if r.maxLine != DefaultMaxLineSize {
+		line("max line size: %d bytes", r.maxLine)
+	}
+	if r.minFree > 0 {
+		line("rotate below free space: %d bytes", r.minFree)
+	}
+	if r.idle > 0 {
+		line("rotate when idle for: %v", r.idle)
+	}
+	if r.inTimeout > 0 {
+		line("input timeout: %v", r.inTimeout)
+	}
+	if r.every > 0 {
+		line("rotate every: %v (aligned to midnight)", r.every)
+	}
+	if r.segAge > 0 {
+		line("rotate segments older than: %v", r.segAge)
+	}
+	if r.lineTime != nil {
+		field := r.lineTime.Field
+		if field == "" {
+			field = "start of line"
+		}
+		line("rotate by line time: every %v (%s, layout %q)", r.lineTime.Window, field, r.lineTime.Layout)
+	}
+	if r.reorder != nil {
+		line("reorder: within %v, up to %d lines", r.reorder.Window, r.reorder.MaxLines)
+	}
+	if r.sentinel != nil {
+		line("rotate on match: %q (%v)", r.sentinel, r.sentAt)
+	}
+	if r.minSize > 0 {
+		line("minimum size for time-triggered rotation: %d bytes", r.minSize)
+	}
+	if r.jitter > 0 {
+		line("jitter: up to %v (per rotation: %t)", r.jitter, r.jitterEach)
+	}
+	if r.sealOnExit {
+		line("rotate on exit: true")
+	}
+	if r.maxRotPM > 0 {
+		line("max rotations per minute: %d (exit when exceeded: %t)", r.maxRotPM, r.failOnMax)
+	}
+
+	if r.ring > 0 {
+		line("ring: %d uncompressed segments", r.ring)
+		return b.String()
+	}
+	line("naming: %s", filepath.Base(r.naming.format(1)))
+	if r.keepPrev {
+		line("previous segment kept at: %s", prevName(r.filename))
+	}
+	if r.copyTrunc {
+		line("copytruncate: true")
+	}
+	comp := r.comp
+	if z, ok := comp.(Zstd); ok {
+		z.Dict = nil
+		comp = z
+	}
+	if r.zNone {
+		line("compressor: none")
+	} else {
+		line("compressor: %s, extension %q", strings.TrimPrefix(fmt.Sprintf("%T%+v", comp, comp), "rotator."), r.ext)
+	}
+	if r.dictFile != "" {
+		line("zstd dictionary: %s (train on %d segments if missing)", r.dictFile, r.dictTrain)
+	}
+	if r.dailyTar {
+		line("daily tarballs: true")
+	}
+	if r.addressed {
+		line("content-addressed: true (index %s)", filepath.Base(r.naming.index))
+	}
+	if r.coalesce > 0 {
+		line("coalesce below: %d bytes", r.coalesce)
+	}
+	if r.zMinSize > 0 {
+		line("compress from: %d bytes", r.zMinSize)
+	}
+	if r.zSlots != nil {
+		line("max pending compressions: %d", cap(r.zSlots))
+	}
+	if r.zTimeout > 0 {
+		line("compress timeout: %v", r.zTimeout)
+	}
+	if r.tempDir != "" {
+		line("temp dir: %s", r.tempDir)
+	}
+	if r.verify {
+		line("verify archives: true")
+	}
+	if r.keepPlain {
+		line("keep uncompressed: true")
+	}
+	line("on collision: %v", r.collide)
+	if r.manifest {
+		line("manifest: %s", filepath.Base(manifestName(r.filename)))
+	}
+	if r.sink != nil {
+		line("upload to: %v (remove uploaded: %t)", r.sink, r.rmUploaded)
+	}
+	if r.onArchive != nil {
+		line("on archive: hook set")
+	}
+
+	if r.retention.enabled() {
+		if r.retention.keep > 0 {
+			line("keep: %d archives", r.retention.keep)
+		}
+		if r.retention.keepDaily > 0 {
+			line("keep daily: %d days", r.retention.keepDaily)
+		}
+		if r.retention.maxAge > 0 {
+			line("max age: %v", r.retention.maxAge)
+		}
+		if r.retention.maxTotal > 0 {
+			line("max total size: %d bytes", r.retention.maxTotal)
+		}
+	} else {
+		line("retention: keep all")
+	}
+	if r.pruneGrace > 0 {
+		line("prune grace: %v", r.pruneGrace)
+	}
+
+	return b.String()
+}
+
+func valueOr(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+func (p WritePolicy) String() string {
+	switch p {
+	case WriteBlock:
+		return "block"
+	case WriteDrop:
+		return "drop"
+	}
+	return fmt.Sprintf("WritePolicy(%d)", int(p))
+}
+
+func (p OversizePolicy) String() string {
+	switch p {
+	case OversizeOverflow:
+		return "overflow"
+	case OversizeTruncate:
+		return "truncate"
+	case OversizeSplit:
+		return "split"
+	}
+	return fmt.Sprintf("OversizePolicy(%d)", int(p))
+}
+
+func (p CollisionPolicy) String() string {
+	switch p {
+	case CollisionOverwrite:
+		return "overwrite"
+	case CollisionFail:
+		return "fail"
+	case CollisionSkip:
+		return "skip"
+	}
+	return fmt.Sprintf("CollisionPolicy(%d)", int(p))
+}
+
+func (p SentinelPosition) String() string {
+	switch p {
+	case SentinelAfter:
+		return "after"
+	case SentinelBefore:
+		return "before"
+	case SentinelDrop:
+		return "drop"
+	}
+	return fmt.Sprintf("SentinelPosition(%d)", int(p))
+}
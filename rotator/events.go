@@ -0,0 +1,87 @@
+package rotator
+
+import "time"
+
+// eventBuffer is the capacity of the channel returned by Events.
+const eventBuffer = 64
+
+// An EventType identifies the kind of an Event.
+type EventType int
+
+const (
+	// RotationStarted is sent once the logfile has been moved aside and a
+	// fresh one opened. Segment holds the path the old logfile was moved to.
+	RotationStarted EventType = iota
+
+	// RotationCompleted is sent once a rotated segment has been compressed.
+	// Segment holds the path of the uncompressed segment and Archive the
+	// path of the compressed archive, which is the same as Segment if the
+	// segment was left uncompressed because of Config.CompressMinSize.
+	RotationCompleted
+
+	// CompressionFailed is sent when a rotated segment could not be
+	// compressed. Segment holds its path and Err the reason.
+	CompressionFailed
+
+	// Pruned is sent for each file deleted by the retention policy. Archive
+	// holds its path.
+	Pruned
+
+	// WriteFailed is sent when a write to the logfile fails, after any
+	// retries. Segment holds the path of the logfile and Err the reason.
+	WriteFailed
+)
+
+var eventTypeNames = [...]string{
+	RotationStarted:   "RotationStarted",
+	RotationCompleted: "RotationCompleted",
+	CompressionFailed: "CompressionFailed",
+	Pruned:            "Pruned",
+	WriteFailed:       "WriteFailed",
+}
+
+func (t EventType) String() string {
+	if t < 0 || int(t) >= len(eventTypeNames) {
+		return "EventType(?)"
+	}
+	return eventTypeNames[t]
+}
+
+// An Event describes something that happened to the logfile or its archives.
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Segment string
+	Archive string
+	Err     error
+}
+
+// Events returns a channel on which events are delivered. The channel is
+// buffered, and events are dropped rather than delivered late if it is
+// full, so that a slow consumer never holds up logging; see EventsDropped.
+// The channel is closed by Close.
+func (r *Rotator) Events() <-chan Event {
+	return r.events
+}
+
+// EventsDropped returns the number of events that were dropped because the
+// channel returned by Events was full.
+func (r *Rotator) EventsDropped() uint64 {
+	return r.dropped.Load()
+}
+
+// emit delivers an event without blocking. Events after Close are
+// discarded.
+func (r *Rotator) emit(e Event) {
+	e.Time = time.Now()
+	r.eventsMu.RLock()
+	defer r.eventsMu.RUnlock()
+	if r.evClosed {
+		return
+	}
+	select {
+	case r.events <- e:
+	default:
+		r.dropped.Add(1)
+	}
+}
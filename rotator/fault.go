@@ -0,0 +1,99 @@
+package rotator
+
+import "io/fs"
+
+// A faultFunc decides whether the file system operation op on the file name
+// fails, and with what error. The operations are "open", "rename" (named
+// after the old path), "remove", "write", "sync", "truncate" and "symlink".
+type faultFunc func(op, name string) error
+
+// withFaults wraps fsys so that each operation on it, and on the files it
+// opens, first consults fail, and returns the error it returns instead of
+// being performed. It lets tests fail a chosen step of rotation, such as
+// the rename of a segment or a write to a partial archive, and check what
+// is left behind; it is deliberately not reachable through Config.
+func withFaults(fsys FS, fail faultFunc) FS {
+	f := &faultFS{fsys, fail}
+	if _, ok := fsys.(SymlinkFS); ok {
+		return &faultSymlinkFS{f}
+	}
+	return f
+}
+
+// faultFS implements withFaults.
+type faultFS struct {
+	FS
+	fail faultFunc
+}
+
+func (f *faultFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	if err := f.fail("open", name); err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	file, err := f.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{file, f.fail}, nil
+}
+
+func (f *faultFS) Rename(oldpath, newpath string) error {
+	if err := f.fail("rename", oldpath); err != nil {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: err}
+	}
+	return f.FS.Rename(oldpath, newpath)
+}
+
+func (f *faultFS) Remove(name string) error {
+	if err := f.fail("remove", name); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return f.FS.Remove(name)
+}
+
+// faultSymlinkFS is a faultFS whose underlying FS supports symlinks.
+type faultSymlinkFS struct {
+	*faultFS
+}
+
+func (f *faultSymlinkFS) Symlink(oldname, newname string) error {
+	if err := f.fail("symlink", newname); err != nil {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: err}
+	}
+	return f.FS.(SymlinkFS).Symlink(oldname, newname)
+}
+
+func (f *faultSymlinkFS) Readlink(name string) (string, error) {
+	return f.FS.(SymlinkFS).Readlink(name)
+}
+
+func (f *faultSymlinkFS) Lstat(name string) (fs.FileInfo, error) {
+	return f.FS.(SymlinkFS).Lstat(name)
+}
+
+// faultFile is a File opened by a faultFS.
+type faultFile struct {
+	File
+	fail faultFunc
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	if err := f.fail("write", f.Name()); err != nil {
+		return 0, &fs.PathError{Op: "write", Path: f.Name(), Err: err}
+	}
+	return f.File.Write(p)
+}
+
+func (f *faultFile) Sync() error {
+	if err := f.fail("sync", f.Name()); err != nil {
+		return &fs.PathError{Op: "sync", Path: f.Name(), Err: err}
+	}
+	return f.File.Sync()
+}
+
+func (f *faultFile) Truncate(size int64) error {
+	if err := f.fail("truncate", f.Name()); err != nil {
+		return &fs.PathError{Op: "truncate", Path: f.Name(), Err: err}
+	}
+	return f.File.Truncate(size)
+}
@@ -0,0 +1,228 @@
+package rotator
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errInjected = errors.New("injected failure")
+
+// failOnce returns a faultFunc that fails the first operation op on the
+// file with the base name name.
+func failOnce(op, name string) faultFunc {
+	return failNth(op, name, 1)
+}
+
+// failNth is like failOnce, but fails the nth such operation instead.
+func failNth(op, name string, n int64) faultFunc {
+	var seen atomic.Int64
+	return func(o, path string) error {
+		if o == op && filepath.Base(path) == name && seen.Add(1) == n {
+			return errInjected
+		}
+		return nil
+	}
+}
+
+// faultRotator returns a Rotator writing /logs/app.log in a memFS, through
+// withFaults with fail, and what it logs.
+func faultRotator(t *testing.T, cfg Config, fail faultFunc) (*Rotator, *memFS, *bytes.Buffer) {
+	t.Helper()
+	m := newMemFS("/logs")
+	var errs bytes.Buffer
+	cfg.Filename = "/logs/app.log"
+	cfg.ThresholdKB = 1
+	cfg.FS = withFaults(m, fail)
+	cfg.ErrorLog = log.New(&errs, "", 0)
+	r, err := NewWithConfig(nil, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, m, &errs
+}
+
+var faultLine = strings.Repeat("x", 99) + "\n"
+
+// A failed rename of the logfile fails the write that would have rotated
+// it, and leaves everything in place for the next rotation.
+func TestFaultRotateRename(t *testing.T) {
+	r, m, _ := faultRotator(t, Config{}, failOnce("rename", "app.log"))
+	written := 0
+	for i := 0; i < 25; i++ {
+		_, err := r.Write([]byte(faultLine))
+		switch {
+		case err == nil:
+			written++
+		case i != 10 || !errors.Is(err, errInjected):
+			t.Errorf("write %d: %v", i, err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	var got []byte
+	for _, name := range []string{"app.log.1.gz", "app.log.2.gz"} {
+		got = append(got, gunzip(t, m.read(t, "/logs/"+name))...)
+	}
+	got = append(got, m.read(t, "/logs/app.log")...)
+	if want := strings.Repeat(faultLine, written); string(got) != want {
+		t.Errorf("archives and logfile hold %d bytes, want %d; files: %v", len(got), len(want), m.names("/logs"))
+	}
+}
+
+// A failed write to an archive removes the partial archive and keeps the
+// segment, for the compression to be retried.
+func TestFaultArchiveWrite(t *testing.T) {
+	r, m, _ := faultRotator(t, Config{}, failOnce("write", "app.log.1.gz"))
+	for i := 0; i < 11; i++ {
+		if _, err := r.Write([]byte(faultLine)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	failed := false
+	for e := range r.Events() {
+		failed = failed || e.Type == CompressionFailed && errors.Is(e.Err, errInjected)
+	}
+
+	if !failed {
+		t.Error("no CompressionFailed event")
+	}
+	if names := m.names("/logs"); strings.Join(names, " ") != "app.log app.log.1" {
+		t.Errorf("got files %v, want the logfile and the uncompressed segment", names)
+	}
+	if got, want := string(m.read(t, "/logs/app.log.1")), strings.Repeat(faultLine, 10); got != want {
+		t.Errorf("segment holds %d bytes, want %d", len(got), len(want))
+	}
+}
+
+// A failed removal of an archive by retention is logged, and the archive is
+// removed by the next prune.
+func TestFaultPruneRemove(t *testing.T) {
+	r, m, errs := faultRotator(t, Config{Keep: 1}, failOnce("remove", "app.log.1.gz"))
+	for i := 0; i < 31; i++ {
+		if _, err := r.Write([]byte(faultLine)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	if !strings.Contains(errs.String(), errInjected.Error()) {
+		t.Errorf("logged %q, want the failed removal", errs.String())
+	}
+	if names := m.names("/logs"); strings.Join(names, " ") != "app.log app.log.3.gz" {
+		t.Errorf("got files %v, want the logfile and the newest archive", names)
+	}
+}
+
+// A logfile that can't be reopened after KeepPrev has moved it is moved
+// back, and the segment already numbered is kept for compression.
+func TestFaultKeepPrevOpen(t *testing.T) {
+	// The first open is NewWithConfig's, the second the first rotation's.
+	r, m, _ := faultRotator(t, Config{KeepPrev: true}, failNth("open", "app.log", 3))
+	written := 0
+	for i := 0; i < 35; i++ {
+		_, err := r.Write([]byte(faultLine))
+		switch {
+		case err == nil:
+			written++
+		case i != 20 || !errors.Is(err, errInjected):
+			t.Errorf("write %d: %v", i, err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	got := m.read(t, "/logs/app.log.1")
+	got = append(got, gunzip(t, m.read(t, "/logs/app.log.2.gz"))...)
+	for _, name := range []string{"app.log.prev", "app.log"} {
+		got = append(got, m.read(t, "/logs/"+name)...)
+	}
+	if want := strings.Repeat(faultLine, written); string(got) != want {
+		t.Errorf("segments hold %d bytes, want %d; files: %v", len(got), len(want), m.names("/logs"))
+	}
+}
+
+// A logfile that can't be reopened after being moved into its ring slot is
+// moved back, and writing carries on in it.
+func TestFaultRingOpen(t *testing.T) {
+	r, m, _ := faultRotator(t, Config{Ring: 2}, failNth("open", "app.log", 2))
+	written := 0
+	for i := 0; i < 15; i++ {
+		_, err := r.Write([]byte(faultLine))
+		switch {
+		case err == nil:
+			written++
+		case i != 10 || !errors.Is(err, errInjected):
+			t.Errorf("write %d: %v", i, err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	got := append(m.read(t, "/logs/app.log.1"), m.read(t, "/logs/app.log")...)
+	if want := strings.Repeat(faultLine, written); string(got) != want {
+		t.Errorf("segments hold %d bytes, want %d; files: %v", len(got), len(want), m.names("/logs"))
+	}
+}
+
+// A failed write of output held by FlushInterval is reported to the Write
+// that triggered it, without discarding that Write's data, and what was
+// held is counted as dropped.
+func TestFaultHeldWrite(t *testing.T) {
+	r, m, _ := faultRotator(t, Config{FlushInterval: time.Hour, FlushSize: 300}, failOnce("write", "app.log"))
+	for i := 0; i < 5; i++ {
+		_, err := r.Write([]byte(faultLine))
+		if (i == 2) != errors.Is(err, errInjected) {
+			t.Errorf("write %d: %v", i, err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	if got, want := string(m.read(t, "/logs/app.log")), strings.Repeat(faultLine, 2); got != want {
+		t.Errorf("logfile holds %d bytes, want %d", len(got), len(want))
+	}
+	if s := r.Stats(); s.DroppedBytes != 200 || s.DroppedLines != 2 {
+		t.Errorf("dropped %d bytes and %d lines, want the 2 lines held", s.DroppedBytes, s.DroppedLines)
+	}
+
+	// Run stops on such a failure with FailOnWriteError.
+	m = newMemFS("/logs")
+	in := strings.NewReader(strings.Repeat(faultLine, 5))
+	r, err := NewWithConfig(in, Config{Filename: "/logs/app.log", ThresholdKB: 1, FlushInterval: time.Hour, FlushSize: 300, FailOnWriteError: true, FS: withFaults(m, failOnce("write", "app.log")), ErrorLog: log.New(io.Discard, "", 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(); !errors.Is(err, errInjected) {
+		t.Errorf("Run returned %v, want the failed write", err)
+	}
+	r.Close()
+	for range r.Events() {
+	}
+}
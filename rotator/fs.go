@@ -0,0 +1,133 @@
+package rotator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// An FS provides the file system operations performed on the logfile and its
+// archives. Supplying one in Config lets a Rotator run against something
+// other than the operating system's file system, such as an in-memory fake
+// in tests. Names are paths as accepted by the os package.
+type FS interface {
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+	Stat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+}
+
+// A File is an open file in an FS. *os.File implements it.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	io.Closer
+	Name() string
+	Stat() (fs.FileInfo, error)
+	Chmod(mode fs.FileMode) error
+	Truncate(size int64) error
+	Sync() error
+}
+
+// osFS is the FS of the operating system.
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Rename(oldpath, newpath string) error       { return os.Rename(oldpath, newpath) }
+func (osFS) Remove(name string) error                   { return os.Remove(name) }
+func (osFS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+
+// openFile is like fsys.OpenFile, but sets the mode of the file to perm
+// regardless of the umask.
+func openFile(fsys FS, name string, flag int, perm os.FileMode) (File, error) {
+	f, err := fsys.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// writeFileAtomic writes b to the file name, replacing it atomically, so
+// that readers never see a partial file.
+func writeFileAtomic(fsys FS, name string, b []byte, mode os.FileMode) error {
+	tmp := name + ".tmp"
+	f, err := openFile(fsys, tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fsys.Remove(tmp)
+		return err
+	}
+	return fsys.Rename(tmp, name)
+}
+
+// createTemp creates a new file in dir, readable and writable only by its
+// owner, whose name begins with prefix.
+func createTemp(fsys FS, dir, prefix string) (File, error) {
+	for i := 0; i < 100; i++ {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 36)+".tmp")
+		f, err := fsys.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+		if !errors.Is(err, fs.ErrExist) {
+			return f, err
+		}
+	}
+	return nil, &os.PathError{Op: "createtemp", Path: filepath.Join(dir, prefix+"*"), Err: fs.ErrExist}
+}
+
+// probeDir checks that files can be created in and removed from dir, as
+// rotation requires, so that a read-only directory is reported right away
+// rather than at the first rotation.
+func probeDir(fsys FS, dir string) error {
+	f, err := createTemp(fsys, dir, ".probe-")
+	if err == nil {
+		f.Close()
+		err = fsys.Remove(f.Name())
+	}
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %w", dir, err)
+	}
+	return nil
+}
+
+// syncDir flushes the directory dir to disk, so that renames and newly
+// created files in it survive a crash. Directories can't be synced on
+// Windows, where this does nothing.
+func syncDir(fsys FS, dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := fsys.OpenFile(dir, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
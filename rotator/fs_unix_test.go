@@ -0,0 +1,43 @@
+//go:build unix
+
+package rotator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestModeIgnoresUmask(t *testing.T) {
+	defer syscall.Umask(syscall.Umask(077))
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "app.log")
+	r, err := NewWithConfig(nil, Config{Filename: filename, ThresholdKB: 1, Mode: 0664})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat("x", 99) + "\n"
+	for i := 0; i < 20; i++ {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	for _, name := range []string{filename, filename + ".1.gz"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0664 {
+			t.Errorf("%s has mode %v, want %v", filepath.Base(name), mode, os.FileMode(0664))
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package rotator
+
+import (
+	"sync"
+	"time"
+)
+
+// health tracks the outcome of the most recent write and compression.
+type health struct {
+	mu        sync.Mutex
+	write     error
+	writeFail time.Time
+	compress  error
+}
+
+// setWrite records the outcome of a write, and reports whether it is the
+// first to fail since one succeeded.
+func (h *health) setWrite(err error) (first bool) {
+	h.mu.Lock()
+	first = err != nil && h.write == nil
+	h.write = err
+	if err != nil {
+		h.writeFail = time.Now()
+	}
+	h.mu.Unlock()
+	return first
+}
+
+// writeFailedWithin reports whether the most recent write failed, less than
+// d ago.
+func (h *health) writeFailedWithin(d time.Duration) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.write != nil && time.Since(h.writeFail) < d
+}
+
+func (h *health) setCompress(err error) {
+	h.mu.Lock()
+	h.compress = err
+	h.mu.Unlock()
+}
+
+// Healthy reports whether the Rotator is working normally. It returns false,
+// along with the error, if the most recent write to the logfile or the most
+// recent compression failed. It returns true again once the failing
+// operation next succeeds.
+func (r *Rotator) Healthy() (bool, error) {
+	r.health.mu.Lock()
+	defer r.health.mu.Unlock()
+
+	if r.health.write != nil {
+		return false, r.health.write
+	}
+	if r.health.compress != nil {
+		return false, r.health.compress
+	}
+	return true, nil
+}
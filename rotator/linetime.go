@@ -0,0 +1,110 @@
+package rotator
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// LineTime configures rotation by the timestamps carried in the lines
+// themselves rather than by the time they arrive. The logfile is rotated
+// whenever a line's timestamp falls in a later window than the lines before
+// it, so each archive covers a fixed window of time even when old data is
+// replayed. Lines without a usable timestamp, or with one from an earlier
+// window, are written to the current segment and reported to the ErrorLog.
+type LineTime struct {
+	// Field is the member of a JSON object holding the timestamp. If
+	// empty, the timestamp is taken from the start of the line.
+	Field string
+
+	// Layout is the time layout of the timestamp, as for time.Parse. It
+	// defaults to time.RFC3339.
+	Layout string
+
+	// Window is the span of time covered by each segment, such as
+	// time.Hour. Windows are aligned to UTC.
+	Window time.Duration
+}
+
+// lineTimer tracks the timestamp window of the current segment.
+type lineTimer struct {
+	LineTime
+	fields int             // number of space-separated fields in Layout
+	window time.Time       // start of the current segment's window
+	warned map[string]bool // problems already reported this segment
+}
+
+func newLineTimer(lt *LineTime) *lineTimer {
+	if lt == nil || lt.Window <= 0 {
+		return nil
+	}
+	t := &lineTimer{LineTime: *lt}
+	if t.Layout == "" {
+		t.Layout = time.RFC3339
+	}
+	t.fields = strings.Count(t.Layout, " ") + 1
+	return t
+}
+
+// startsWindow reports whether line belongs to a later window than the
+// current segment, meaning the logfile has to be rotated before it is
+// written.
+func (t *lineTimer) startsWindow(r *Rotator, line []byte) bool {
+	if t == nil {
+		return false
+	}
+
+	ts, ok := t.parse(line)
+	if !ok {
+		t.warn(r, "line without a timestamp written to the current segment")
+		return false
+	}
+
+	w := ts.Truncate(t.Window)
+	switch {
+	case t.window.IsZero():
+		t.window = w
+	case w.After(t.window):
+		t.window = w
+		t.warned = nil
+		return true
+	case w.Before(t.window):
+		t.warn(r, "out-of-order line written to the current segment")
+	}
+	return false
+}
+
+// warn reports a problem with a line, once per segment.
+func (t *lineTimer) warn(r *Rotator, msg string) {
+	if !t.warned[msg] {
+		r.logf("%s", msg)
+		if t.warned == nil {
+			t.warned = make(map[string]bool)
+		}
+		t.warned[msg] = true
+	}
+}
+
+// parse extracts the timestamp from a line.
+func (t *lineTimer) parse(line []byte) (time.Time, bool) {
+	var s string
+	if t.Field != "" {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(line, &obj); err != nil {
+			return time.Time{}, false
+		}
+		if err := json.Unmarshal(obj[t.Field], &s); err != nil {
+			return time.Time{}, false
+		}
+	} else {
+		fields := bytes.SplitN(line, []byte{' '}, t.fields+1)
+		if len(fields) < t.fields {
+			return time.Time{}, false
+		}
+		s = string(bytes.Join(fields[:t.fields], []byte{' '}))
+	}
+
+	ts, err := time.Parse(t.Layout, s)
+	return ts, err == nil
+}
@@ -0,0 +1,71 @@
+package rotator
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"time"
+)
+
+// manifestEntry describes one archive in the manifest.
+type manifestEntry struct {
+	Name    string    `json:"name"`
+	Seq     int       `json:"seq"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+
+	// Dict is the ID of the zstd dictionary the archive was compressed
+	// with, if any.
+	Dict uint32 `json:"dict,omitempty"`
+}
+
+// manifestDict identifies the zstd dictionary new archives are compressed
+// with. Those compressed before it was trained don't use it.
+type manifestDict struct {
+	File string `json:"file"`
+	ID   uint32 `json:"id"`
+}
+
+// manifestName returns the path of the manifest for filename.
+func manifestName(filename string) string {
+	return filename + ".index.json"
+}
+
+// writeManifest rewrites the manifest from the archives currently on disk.
+// The new manifest replaces the old one atomically, so readers never see a
+// partial file.
+func (r *Rotator) writeManifest() error {
+	arcs, err := r.naming.archives()
+	if err != nil {
+		return err
+	}
+
+	entries := make([]manifestEntry, 0, len(arcs))
+	for _, a := range arcs {
+		name := r.naming.archiveName(a)
+		e := manifestEntry{
+			Name:    filepath.Base(name),
+			Seq:     a.Seq,
+			Size:    a.Size,
+			ModTime: a.ModTime,
+		}
+		if _, ok := r.comp.(Zstd); ok && r.dictFile != "" {
+			e.Dict = archiveDictID(r.fs, name)
+		}
+		entries = append(entries, e)
+	}
+
+	var dict *manifestDict
+	if r.zDict != nil {
+		dict = &manifestDict{File: r.dictFile, ID: ZstdDictID(r.zDict)}
+	}
+
+	b, err := json.MarshalIndent(struct {
+		Dict     *manifestDict   `json:"dict,omitempty"`
+		Archives []manifestEntry `json:"archives"`
+	}{dict, entries}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(r.fs, manifestName(r.filename), append(b, '\n'), r.mode)
+}
@@ -0,0 +1,76 @@
+package rotator
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultShutdownMarker is a suggested Config.ShutdownMarker.
+const DefaultShutdownMarker = "--- logrotate stopped at {time}, wrote {lines} lines, {bytes} bytes, {rotations} rotations ---"
+
+// writeMarker writes the shutdown marker, if any, to the logfile, with its
+// placeholders expanded from the current Stats.
+func (r *Rotator) writeMarker() error {
+	if r.marker == "" {
+		return nil
+	}
+
+	st := r.Stats()
+	line := strings.NewReplacer(
+		"{time}", time.Now().Format(time.RFC3339),
+		"{lines}", strconv.FormatUint(st.Lines, 10),
+		"{bytes}", strconv.FormatUint(st.Bytes, 10),
+		"{rotations}", strconv.FormatUint(st.Rotations, 10),
+	).Replace(r.marker)
+	return r.writeRecord(line)
+}
+
+// writeTrailer writes the segment trailer, if any, to the end of the
+// logfile, which is about to be rotated.
+func (r *Rotator) writeTrailer() error {
+	if r.trailer == "" {
+		return nil
+	}
+
+	seq, err := r.nextSeq()
+	if err != nil {
+		return err
+	}
+	line := strings.NewReplacer(
+		"{time}", time.Now().Format(time.RFC3339),
+		"{seq}", strconv.Itoa(seq),
+		"{lines}", strconv.Itoa(r.lineNo),
+		"{bytes}", strconv.FormatInt(r.size, 10),
+	).Replace(r.trailer)
+	return r.writeRecord(line)
+}
+
+// nextSeq returns the sequence number the logfile will be rotated to.
+func (r *Rotator) nextSeq() (int, error) {
+	if r.ring > 0 {
+		return r.ringSlot%r.ring + 1, nil
+	}
+	maxNum, err := r.naming.last()
+	if err != nil {
+		return 0, err
+	}
+	if r.coalesce > 0 && maxNum > 0 && r.canCoalesce(maxNum) {
+		return maxNum, nil
+	}
+	return maxNum + 1, nil
+}
+
+// writeRecord writes s to the logfile as a record of its own, bypassing
+// the processing applied to lines from the input.
+func (r *Rotator) writeRecord(s string) error {
+	buf := append([]byte(s), r.delim)
+	if r.hold {
+		r.held = append(r.held, buf...)
+		r.size += int64(len(buf))
+		return nil
+	}
+	n, err := r.writeOut(buf)
+	r.size += int64(n)
+	return err
+}
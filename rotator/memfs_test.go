@@ -0,0 +1,372 @@
+package rotator
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memFS is an FS held in memory, for tests. Directories must be made with
+// mkdir before files can be created in them. As on Unix, a file that is
+// renamed or removed while open is still written by its open Files.
+type memFS struct {
+	mu    sync.Mutex
+	files map[string]*memData
+	dirs  map[string]bool
+}
+
+// memData is the contents of a file in a memFS.
+type memData struct {
+	data  []byte
+	mode  fs.FileMode
+	mtime time.Time
+}
+
+func newMemFS(dirs ...string) *memFS {
+	m := &memFS{files: make(map[string]*memData), dirs: make(map[string]bool)}
+	for _, d := range dirs {
+		m.mkdir(d)
+	}
+	return m
+}
+
+func (m *memFS) mkdir(dir string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dirs[filepath.Clean(dir)] = true
+}
+
+func (m *memFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	if m.dirs[name] {
+		if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+		}
+		return &memFile{fs: m, name: name, dir: true}, nil
+	}
+	d, ok := m.files[name]
+	switch {
+	case ok && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !ok && !m.dirs[filepath.Dir(name)]:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case !ok:
+		d = &memData{mode: perm &^ 022, mtime: time.Now()}
+		m.files[name] = d
+	case flag&os.O_TRUNC != 0:
+		d.data = nil
+		d.mtime = time.Now()
+	}
+	return &memFile{fs: m, name: name, d: d, flag: flag}, nil
+}
+
+func (m *memFS) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	d, ok := m.files[oldpath]
+	if !ok || !m.dirs[filepath.Dir(newpath)] {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+	delete(m.files, oldpath)
+	m.files[newpath] = d
+	return nil
+}
+
+func (m *memFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	if m.dirs[name] {
+		return memInfo{name: filepath.Base(name), mode: fs.ModeDir | 0755}, nil
+	}
+	d, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return d.info(name), nil
+}
+
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	if !m.dirs[name] {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	var entries []fs.DirEntry
+	for path, d := range m.files {
+		if filepath.Dir(path) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(d.info(path)))
+		}
+	}
+	for path := range m.dirs {
+		if path != name && filepath.Dir(path) == name {
+			entries = append(entries, fs.FileInfoToDirEntry(memInfo{name: filepath.Base(path), mode: fs.ModeDir | 0755}))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// read returns the contents of the file name, failing t if there is none.
+func (m *memFS) read(t testing.TB, name string) []byte {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.files[filepath.Clean(name)]
+	if !ok {
+		t.Fatalf("%s does not exist", name)
+	}
+	return append([]byte(nil), d.data...)
+}
+
+// write creates the file name holding data.
+func (m *memFS) write(name string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[filepath.Clean(name)] = &memData{data: append([]byte(nil), data...), mode: 0644, mtime: time.Now()}
+}
+
+// chtimes sets the modification time of the file name.
+func (m *memFS) chtimes(name string, mtime time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[filepath.Clean(name)].mtime = mtime
+}
+
+// names returns the base names of the files in dir, sorted.
+func (m *memFS) names(dir string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for path := range m.files {
+		if filepath.Dir(path) == filepath.Clean(dir) {
+			names = append(names, filepath.Base(path))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (d *memData) info(name string) memInfo {
+	return memInfo{name: filepath.Base(name), size: int64(len(d.data)), mode: d.mode, mtime: d.mtime}
+}
+
+// memInfo is the fs.FileInfo of a file in a memFS.
+type memInfo struct {
+	name  string
+	size  int64
+	mode  fs.FileMode
+	mtime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() fs.FileMode  { return i.mode }
+func (i memInfo) ModTime() time.Time { return i.mtime }
+func (i memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
+
+// memFile is a File opened by a memFS.
+type memFile struct {
+	fs     *memFS
+	name   string
+	d      *memData
+	dir    bool
+	flag   int
+	off    int64
+	closed bool
+}
+
+func (f *memFile) check(op string) error {
+	if f.closed {
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.dir && op != "sync" && op != "close" && op != "stat" {
+		return &fs.PathError{Op: op, Path: f.name, Err: fs.ErrInvalid}
+	}
+	return nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("read"); err != nil {
+		return 0, err
+	}
+	if f.off >= int64(len(f.d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.d.data[f.off:])
+	f.off += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("write"); err != nil {
+		return 0, err
+	}
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrPermission}
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.off = int64(len(f.d.data))
+	}
+	if end := f.off + int64(len(p)); end > int64(len(f.d.data)) {
+		f.d.data = append(f.d.data, make([]byte, end-int64(len(f.d.data)))...)
+	}
+	copy(f.d.data[f.off:], p)
+	f.off += int64(len(p))
+	f.d.mtime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("seek"); err != nil {
+		return 0, err
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += int64(len(f.d.data))
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("close"); err != nil {
+		return err
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Name() string { return f.name }
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("stat"); err != nil {
+		return nil, err
+	}
+	if f.dir {
+		return memInfo{name: filepath.Base(f.name), mode: fs.ModeDir | 0755}, nil
+	}
+	return f.d.info(f.name), nil
+}
+
+func (f *memFile) Chmod(mode fs.FileMode) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("chmod"); err != nil {
+		return err
+	}
+	f.d.mode = mode.Perm()
+	return nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if err := f.check("truncate"); err != nil {
+		return err
+	}
+	if size < int64(len(f.d.data)) {
+		f.d.data = f.d.data[:size]
+	} else {
+		f.d.data = append(f.d.data, make([]byte, size-int64(len(f.d.data)))...)
+	}
+	return nil
+}
+
+func (f *memFile) Sync() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.check("sync")
+}
+
+// gunzip decompresses data, which may hold several gzip members, failing t
+// if it isn't gzip.
+func gunzip(t testing.TB, data []byte) []byte {
+	t.Helper()
+	z, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestMemFSRotation(t *testing.T) {
+	m := newMemFS("/logs")
+	r, err := NewWithConfig(nil, Config{Filename: "/logs/app.log", ThresholdKB: 1, FS: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	line := strings.Repeat("x", 99) + "\n"
+	for i := 0; i < 50; i++ {
+		want.WriteString(line)
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	// Newer archives have higher numbers, and the live logfile is newest.
+	var got []byte
+	names := m.names("/logs")
+	for i := 1; i < len(names); i++ {
+		got = append(got, gunzip(t, m.read(t, fmt.Sprintf("/logs/app.log.%d.gz", i)))...)
+	}
+	got = append(got, m.read(t, "/logs/app.log")...)
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("archives and logfile hold %d bytes, want the %d written; files: %v", len(got), want.Len(), names)
+	}
+	if len(names) < 4 {
+		t.Errorf("got files %v, want several archives", names)
+	}
+}
@@ -0,0 +1,86 @@
+package rotator
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// segmentMeta describes a segment in its metadata file.
+type segmentMeta struct {
+	Seq     int       `json:"seq"`
+	Lines   int       `json:"lines"`
+	Bytes   int64     `json:"bytes"`
+	First   time.Time `json:"first,omitempty"`
+	Last    time.Time `json:"last,omitempty"`
+	Opened  time.Time `json:"opened"`
+	Rotated time.Time `json:"rotated"`
+	Reason  string    `json:"reason"`
+}
+
+// metaName returns the path of the metadata file of the segment at name.
+func metaName(name string) string {
+	return name + ".meta"
+}
+
+// touch records that data was written to the segment.
+func (r *Rotator) touch() {
+	now := time.Now()
+	if r.first.IsZero() {
+		r.first = now
+	}
+	r.last = now
+}
+
+// writeMeta writes the metadata file of the segment just rotated to name
+// with sequence number seq. When the segment was appended to an existing
+// archive, its counts are added to those already recorded. The file is
+// replaced atomically.
+func (r *Rotator) writeMeta(name string, seq int, appending bool, reason string) error {
+	m := segmentMeta{
+		Seq:     seq,
+		Lines:   r.lineNo,
+		Bytes:   r.size,
+		First:   r.first,
+		Last:    r.last,
+		Opened:  r.opened,
+		Rotated: time.Now(),
+		Reason:  reason,
+	}
+	if appending {
+		var prev segmentMeta
+		if err := readJSON(r.fs, metaName(name), &prev); err == nil {
+			m.Lines += prev.Lines
+			m.Bytes += prev.Bytes
+			m.Opened = prev.Opened
+			if !prev.First.IsZero() {
+				m.First = prev.First
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(r.fs, metaName(name), append(b, '\n'), r.mode)
+}
+
+// readJSON decodes the JSON file at name into v.
+func readJSON(fsys FS, name string, v interface{}) error {
+	f, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
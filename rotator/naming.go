@@ -0,0 +1,470 @@
+package rotator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Built-in naming schemes for rotated segments. Any other value of
+// Config.Naming is treated as a template, in which the following
+// placeholders are expanded:
+//
+//	{name}  the logfile's base name, e.g. "app.log"
+//	{base}  the base name without its extension, e.g. "app"
+//	{ext}   the extension, including the leading dot, e.g. ".log"
+//	{n}     the sequence number of the segment
+//	{time}  the time the segment was rotated, formatted with
+//	        Config.NamingTimeLayout, e.g. "2024-05-03T12-00-00"
+//
+// A template must contain {n} exactly once, and {time} at most once. The
+// compressed file extension is appended to the expanded template.
+const (
+	// NamingSuffix places the sequence number after the whole filename, as
+	// in app.log.1.gz. This is the default.
+	NamingSuffix = "suffix"
+
+	// NamingInfix places the sequence number before the filename's
+	// extension, as in app.1.log.gz or, with "-" as the separator,
+	// app-1.log.gz.
+	NamingInfix = "infix"
+
+	// NamingTimestamp places the rotation time and then the sequence
+	// number after the whole filename, as in
+	// app.log.2024-05-03T12-00-00.1.gz.
+	NamingTimestamp = "timestamp"
+)
+
+// DefaultNamingTimeLayout is the default layout of {time} in naming
+// templates. It avoids colons, which Windows doesn't allow in filenames.
+const DefaultNamingTimeLayout = "2006-01-02T15-04-05"
+
+// namer builds and parses the names of rotated segments for one logfile.
+type namer struct {
+	fs     FS
+	dir    string
+	prefix string
+	suffix string
+
+	// layout is the time layout of {time}, if the template contains it, in
+	// which case it remains in prefix or suffix.
+	layout string
+
+	// index, if set, is the path of the index of content-addressed
+	// archives, whose names carry no sequence number.
+	index string
+
+	// exts holds the extensions, without the dot, of the files recognised
+	// as archives, as set by archiveExts.
+	exts map[string]bool
+}
+
+// archiveExts returns the extensions recognised on archives: ext, the one
+// given to new archives, and those of the built-in compressors, so that
+// archives made before a change of compressor are still numbered and
+// pruned.
+func archiveExts(ext string) map[string]bool {
+	exts := make(map[string]bool)
+	if ext != "" {
+		exts[ext] = true
+	}
+	for _, c := range []Compressor{Gzip{}, Zstd{}, Brotli{}, XZ{}, Bzip2{}} {
+		exts[c.Ext()] = true
+	}
+	return exts
+}
+
+// A fileKind says what a file is to the segment it belongs to.
+type fileKind int
+
+const (
+	notSegment  fileKind = iota
+	segmentFile          // the uncompressed segment
+	archiveFile          // the segment's archive
+	sidecarFile          // its metadata, or the index of its archive
+)
+
+func newNamer(fsys FS, filename, scheme, sep, layout string) (*namer, error) {
+	if sep == "" {
+		sep = "."
+	}
+	if layout == "" {
+		layout = DefaultNamingTimeLayout
+	}
+
+	var tmpl string
+	switch scheme {
+	case "", NamingSuffix:
+		tmpl = "{name}" + sep + "{n}"
+	case NamingInfix:
+		tmpl = "{base}" + sep + "{n}{ext}"
+	case NamingTimestamp:
+		tmpl = "{name}" + sep + "{time}" + sep + "{n}"
+	default:
+		tmpl = scheme
+	}
+
+	if strings.Count(tmpl, "{n}") != 1 {
+		return nil, errors.New("naming template must contain {n} exactly once")
+	}
+	if strings.Count(tmpl, "{time}") > 1 {
+		return nil, errors.New("naming template must contain {time} at most once")
+	}
+	if strings.ContainsAny(tmpl, `/\`) {
+		return nil, errors.New("naming template must not contain a path separator")
+	}
+
+	name := filepath.Base(filename)
+	ext := filepath.Ext(name)
+	tmpl = strings.NewReplacer(
+		"{name}", name,
+		"{base}", strings.TrimSuffix(name, ext),
+		"{ext}", ext,
+	).Replace(tmpl)
+	i := strings.Index(tmpl, "{n}")
+
+	nm := &namer{
+		fs:     fsys,
+		dir:    filepath.Dir(filename),
+		prefix: tmpl[:i],
+		suffix: tmpl[i+len("{n}"):],
+	}
+	if strings.Contains(tmpl, "{time}") {
+		if strings.ContainsAny(time.Now().Format(layout), `/\`) {
+			return nil, errors.New("naming time layout must not produce a path separator")
+		}
+		nm.layout = layout
+	}
+	return nm, nil
+}
+
+// timed reports whether segment names include the rotation time, so that
+// the name of an existing segment can't be derived from its number alone.
+func (nm *namer) timed() bool {
+	return nm.layout != ""
+}
+
+// format returns the path of the segment with sequence number n, rotated
+// now if the names include the rotation time.
+func (nm *namer) format(n int) string {
+	name := nm.prefix + strconv.Itoa(n) + nm.suffix
+	if nm.timed() {
+		name = strings.Replace(name, "{time}", time.Now().Format(nm.layout), 1)
+	}
+	return filepath.Join(nm.dir, name)
+}
+
+// parse returns the sequence number of the segment with the given base name,
+// which may carry a compressed file extension.
+func (nm *namer) parse(name string) (int, bool) {
+	n, _, ok := nm.split(name)
+	return n, ok
+}
+
+// kind returns the sequence number of the segment the file with the given
+// base name belongs to, and what the file is to it. Anything else carrying
+// a segment's name, such as a temporary file, is notSegment.
+func (nm *namer) kind(name string) (int, fileKind) {
+	n, rest, ok := nm.split(name)
+	switch {
+	case !ok:
+		return 0, notSegment
+	case rest == "":
+		return n, segmentFile
+	case rest == ".meta":
+		return n, sidecarFile
+	case nm.exts[rest[1:]]:
+		return n, archiveFile
+	case strings.HasSuffix(rest, ".gzi") && nm.exts[strings.TrimSuffix(rest[1:], ".gzi")]:
+		return n, sidecarFile
+	}
+	return 0, notSegment
+}
+
+// archiveName returns the file of a that holds the segment: its archive,
+// or the segment itself while it is uncompressed. Content-addressed
+// archives carry no sequence number, so they are the files of a that kind
+// doesn't know.
+func (nm *namer) archiveName(a Archive) string {
+	for _, f := range a.Files {
+		if _, kind := nm.kind(filepath.Base(f)); kind == archiveFile || kind == notSegment {
+			return f
+		}
+	}
+	return a.Files[0]
+}
+
+// split returns the sequence number of the segment with the given base name
+// and whatever follows the expanded template, such as a compressed file
+// extension.
+func (nm *namer) split(name string) (int, string, bool) {
+	if nm.timed() {
+		return nm.splitTimed(name)
+	}
+	if !strings.HasPrefix(name, nm.prefix) {
+		return 0, "", false
+	}
+	return splitNum(name[len(nm.prefix):], nm.suffix)
+}
+
+// splitNum is split for what follows the prefix: the sequence number, then
+// suffix, then whatever follows the template.
+func splitNum(rest, suffix string) (int, string, bool) {
+	n, rest, ok := leadingNum(rest)
+	if !ok || !strings.HasPrefix(rest, suffix) {
+		return 0, "", false
+	}
+	rest = rest[len(suffix):]
+	if rest != "" && rest[0] != '.' {
+		return 0, "", false
+	}
+
+	return n, rest, true
+}
+
+// leadingNum returns the number s starts with and what follows it.
+func leadingNum(s string) (int, string, bool) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	if i == 0 {
+		return 0, "", false
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, "", false
+	}
+	return n, s[i:], true
+}
+
+// splitTimed is split for names that include the rotation time, which must
+// parse with the layout. As the formatted time may contain anything that
+// surrounds it in the template, such as the dots of 2006.01.02, each way of
+// cutting it out of the name is tried until one parses.
+func (nm *namer) splitTimed(name string) (int, string, bool) {
+	if before, after, ok := strings.Cut(nm.prefix, "{time}"); ok {
+		if !strings.HasPrefix(name, before) {
+			return 0, "", false
+		}
+		name = name[len(before):]
+		for i := 1; i <= len(name); i++ {
+			if !strings.HasPrefix(name[i:], after) {
+				continue
+			}
+			if _, err := time.Parse(nm.layout, name[:i]); err != nil {
+				continue
+			}
+			if n, rest, ok := splitNum(name[i+len(after):], nm.suffix); ok {
+				return n, rest, true
+			}
+		}
+		return 0, "", false
+	}
+
+	before, after, _ := strings.Cut(nm.suffix, "{time}")
+	if !strings.HasPrefix(name, nm.prefix) {
+		return 0, "", false
+	}
+	n, name, ok := leadingNum(name[len(nm.prefix):])
+	if !ok || !strings.HasPrefix(name, before) {
+		return 0, "", false
+	}
+	name = name[len(before):]
+	for i := 1; i <= len(name); i++ {
+		rest := name[i:]
+		if !strings.HasPrefix(rest, after) {
+			continue
+		}
+		rest = rest[len(after):]
+		if rest != "" && rest[0] != '.' {
+			continue
+		}
+		if _, err := time.Parse(nm.layout, name[:i]); err == nil {
+			return n, rest, true
+		}
+	}
+	return 0, "", false
+}
+
+// last returns the highest sequence number among the existing segments, or 0
+// if there are none.
+func (nm *namer) last() (int, error) {
+	entries, err := nm.fs.ReadDir(nm.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	maxNum := 0
+	for _, e := range entries {
+		if n, kind := nm.kind(e.Name()); kind != notSegment && n > maxNum {
+			maxNum = n
+		}
+	}
+	if nm.index != "" {
+		cas, err := readCASIndex(nm.fs, nm.index)
+		if err != nil {
+			return 0, err
+		}
+		for _, c := range cas {
+			if c.seq > maxNum {
+				maxNum = c.seq
+			}
+		}
+	}
+	return maxNum, nil
+}
+
+// renumberJournal returns the path of the journal kept by renumberReversed
+// for filename.
+func renumberJournal(filename string) string {
+	return filename + ".renumber"
+}
+
+// A renumbering is the plan carried out by renumberReversed. It is kept in
+// a journal while the archives are renamed, so that a renumbering
+// interrupted by a crash or a failed rename can be finished on the next
+// start rather than leaving archives under temporary names.
+type renumbering struct {
+	Moves []renumberMove `json:"moves"`
+
+	// Parked is set once every archive has been moved to its temporary
+	// name, after which none is left under its old one.
+	Parked bool `json:"parked"`
+}
+
+// A renumberMove renames one file, by base name, via a temporary name.
+type renumberMove struct {
+	From string `json:"from"`
+	Tmp  string `json:"tmp"`
+	To   string `json:"to"`
+}
+
+// renumberReversed detects archives numbered the way logrotate(8) numbers
+// them, with 1 being the newest, and renumbers them so that higher numbers
+// are newer, as the Rotator numbers them. The same set of sequence numbers
+// is reused in reverse order. The plan is written to journal first; see
+// resumeRenumber.
+func (nm *namer) renumberReversed(journal string, mode os.FileMode) error {
+	arcs, err := nm.archives()
+	if err != nil || !reversed(arcs) {
+		return err
+	}
+
+	var plan renumbering
+	for i, a := range arcs {
+		seq := arcs[len(arcs)-1-i].Seq
+		base := filepath.Base(nm.format(a.Seq))
+		for _, f := range a.Files {
+			f = filepath.Base(f)
+			to := filepath.Base(nm.format(seq)) + strings.TrimPrefix(f, base)
+			plan.Moves = append(plan.Moves, renumberMove{f, f + ".renumber", to})
+		}
+	}
+	if err := nm.writeRenumbering(journal, plan, mode); err != nil {
+		return err
+	}
+	return nm.renumber(journal, plan, mode)
+}
+
+// resumeRenumber finishes a renumbering left unfinished in journal, if
+// there is one.
+func (nm *namer) resumeRenumber(journal string, mode os.FileMode) error {
+	var plan renumbering
+	err := readJSON(nm.fs, journal, &plan)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", journal, err)
+	}
+	if err := nm.renumber(journal, plan, mode); err != nil {
+		return fmt.Errorf("resuming the renumbering in %s: %w", journal, err)
+	}
+	return nil
+}
+
+// renumber carries out plan, skipping the moves already done, and removes
+// journal once it is complete. Every file is moved out of the way first, so
+// that no rename clobbers a file that has yet to be moved. If that first
+// step fails, the files already moved are put back; if the second does,
+// journal is left to finish the job on the next start.
+func (nm *namer) renumber(journal string, plan renumbering, mode os.FileMode) error {
+	path := func(name string) string { return filepath.Join(nm.dir, name) }
+	exists := func(name string) (bool, error) {
+		_, err := nm.fs.Stat(path(name))
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	if !plan.Parked {
+		for i, m := range plan.Moves {
+			parked, err := exists(m.Tmp)
+			if err == nil && !parked {
+				err = nm.fs.Rename(path(m.From), path(m.Tmp))
+			}
+			if err != nil {
+				if nm.unpark(plan.Moves[:i]) == nil {
+					nm.fs.Remove(journal)
+				}
+				return err
+			}
+		}
+		plan.Parked = true
+		if err := nm.writeRenumbering(journal, plan, mode); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range plan.Moves {
+		parked, err := exists(m.Tmp)
+		if err == nil && parked {
+			err = nm.fs.Rename(path(m.Tmp), path(m.To))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nm.fs.Remove(journal)
+}
+
+// unpark moves the files of moves back from their temporary names.
+func (nm *namer) unpark(moves []renumberMove) error {
+	var err error
+	for _, m := range moves {
+		if rerr := nm.fs.Rename(filepath.Join(nm.dir, m.Tmp), filepath.Join(nm.dir, m.From)); rerr != nil && !errors.Is(rerr, fs.ErrNotExist) {
+			err = rerr
+		}
+	}
+	return err
+}
+
+func (nm *namer) writeRenumbering(journal string, plan renumbering, mode os.FileMode) error {
+	b, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(nm.fs, journal, append(b, '\n'), mode)
+}
+
+// reversed reports whether arcs, ordered by sequence number, get older as
+// the number increases.
+func reversed(arcs []Archive) bool {
+	if len(arcs) < 2 {
+		return false
+	}
+	for i := 1; i < len(arcs); i++ {
+		if arcs[i].ModTime.After(arcs[i-1].ModTime) {
+			return false
+		}
+	}
+	return arcs[0].ModTime.After(arcs[len(arcs)-1].ModTime)
+}
@@ -0,0 +1,177 @@
+package rotator
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNamerTimed(t *testing.T) {
+	when := time.Date(2026, 10, 15, 7, 57, 37, 0, time.Local)
+	tests := []struct {
+		scheme, sep, layout string
+	}{
+		{NamingTimestamp, ".", ""},
+		{NamingTimestamp, ".", "20060102.150405"},
+		{NamingTimestamp, ".", "2006.01.02"},
+		{NamingTimestamp, "-", "2006-01-02"},
+		{"{base}.{n}.{time}{ext}", ".", "2006.01.02.15"},
+		{"{time}.{n}.{name}", ".", "2006.01.02"},
+		{"{name}.{time}.{n}", ".", "Jan.2.2006"},
+	}
+	for _, tt := range tests {
+		nm, err := newNamer(nil, filepath.Join("logs", "app.log"), tt.scheme, tt.sep, tt.layout)
+		if err != nil {
+			t.Fatalf("%s %q: %v", tt.scheme, tt.layout, err)
+		}
+		name := filepath.Base(nm.prefix + "7" + nm.suffix)
+		name = strings.Replace(name, "{time}", when.Format(nm.layout), 1)
+		for _, ext := range []string{"", ".gz", ".meta"} {
+			n, rest, ok := nm.split(name + ext)
+			if !ok || n != 7 || rest != ext {
+				t.Errorf("%s %q: split(%q) = %d, %q, %t; want 7, %q, true", tt.scheme, tt.layout, name+ext, n, rest, ok, ext)
+			}
+		}
+		if _, ok := nm.parse("app.log.notatime.7.gz"); ok {
+			t.Errorf("%s %q: parsed a name without a time", tt.scheme, tt.layout)
+		}
+	}
+}
+
+func TestNamerUntimed(t *testing.T) {
+	tests := []struct {
+		scheme, sep, name string
+		n                 int
+		rest              string
+		ok                bool
+	}{
+		{NamingSuffix, ".", "app.log.3", 3, "", true},
+		{NamingSuffix, ".", "app.log.12.gz", 12, ".gz", true},
+		{NamingSuffix, ".", "app.log.x.gz", 0, "", false},
+		{NamingSuffix, ".", "app.log.3gz", 0, "", false},
+		{NamingInfix, ".", "app.3.log.gz", 3, ".gz", true},
+		{NamingInfix, "-", "app-3.log", 3, "", true},
+		{NamingInfix, "-", "app.3.log", 0, "", false},
+	}
+	for _, tt := range tests {
+		nm, err := newNamer(nil, "app.log", tt.scheme, tt.sep, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, rest, ok := nm.split(tt.name)
+		if n != tt.n || rest != tt.rest || ok != tt.ok {
+			t.Errorf("%s: split(%q) = %d, %q, %t; want %d, %q, %t", tt.scheme, tt.name, n, rest, ok, tt.n, tt.rest, tt.ok)
+		}
+	}
+}
+
+func TestArchiveFiles(t *testing.T) {
+	m := newMemFS("/logs")
+	for _, name := range []string{
+		"app.log.1.gz", "app.log.1.meta",
+		"app.log.2.meta.tmp", "app.log.3.gz.renumber", "app.log.4.tmp",
+		"app.log.5.meta",
+		"app.log.6.zst", "app.log.6.zst.gzi",
+		"app.log.7",
+	} {
+		m.write("/logs/"+name, nil)
+	}
+	arcs, err := Archives(Config{Filename: "/logs/app.log", FS: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range arcs {
+		var files []string
+		for _, f := range a.Files {
+			files = append(files, filepath.Base(f))
+		}
+		got = append(got, fmt.Sprintf("%d: %s", a.Seq, strings.Join(files, " ")))
+	}
+	want := []string{"1: app.log.1.gz app.log.1.meta", "6: app.log.6.zst app.log.6.zst.gzi", "7: app.log.7"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got archives %q, want %q", got, want)
+	}
+}
+
+func TestAdoptReversed(t *testing.T) {
+	// logrotate(8) left app.log.1.gz as the newest archive.
+	setup := func() *memFS {
+		m := newMemFS("/logs")
+		now := time.Now()
+		for i := 1; i <= 3; i++ {
+			name := fmt.Sprintf("/logs/app.log.%d.gz", i)
+			m.write(name, []byte(fmt.Sprint("archive ", i)))
+			m.chtimes(name, now.Add(-time.Duration(i)*time.Hour))
+		}
+		return m
+	}
+	check := func(t *testing.T, m *memFS) {
+		t.Helper()
+		want := []string{"app.log", "app.log.1.gz", "app.log.2.gz", "app.log.3.gz"}
+		if got := m.names("/logs"); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Fatalf("got files %v, want %v", got, want)
+		}
+		for i := 1; i <= 3; i++ {
+			if got, want := string(m.read(t, fmt.Sprintf("/logs/app.log.%d.gz", i))), fmt.Sprint("archive ", 4-i); got != want {
+				t.Errorf("app.log.%d.gz holds %q, want %q", i, got, want)
+			}
+		}
+	}
+	open := func(fsys FS) error {
+		r, err := NewWithConfig(nil, Config{Filename: "/logs/app.log", ThresholdKB: 1, AdoptReversed: true, FS: fsys})
+		if err == nil {
+			r.Close()
+		}
+		return err
+	}
+	failRename := func(name string) faultFunc {
+		return func(op, path string) error {
+			if op == "rename" && filepath.Base(path) == name {
+				return errors.New("injected failure")
+			}
+			return nil
+		}
+	}
+
+	t.Run("Clean", func(t *testing.T) {
+		m := setup()
+		if err := open(m); err != nil {
+			t.Fatal(err)
+		}
+		check(t, m)
+	})
+
+	// A failure while moving archives out of the way puts them back.
+	t.Run("RollBack", func(t *testing.T) {
+		m := setup()
+		if err := open(withFaults(m, failRename("app.log.3.gz"))); err == nil {
+			t.Fatal("got no error")
+		}
+		want := []string{"app.log", "app.log.1.gz", "app.log.2.gz", "app.log.3.gz"}
+		if got := m.names("/logs"); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Fatalf("got files %v, want %v", got, want)
+		}
+		if got := string(m.read(t, "/logs/app.log.1.gz")); got != "archive 1" {
+			t.Errorf("app.log.1.gz holds %q, want it untouched", got)
+		}
+	})
+
+	// A failure while moving them to their new names is finished on the
+	// next start, even without AdoptReversed.
+	t.Run("Resume", func(t *testing.T) {
+		m := setup()
+		if err := open(withFaults(m, failRename("app.log.2.gz.renumber"))); err == nil {
+			t.Fatal("got no error")
+		}
+		r, err := NewWithConfig(nil, Config{Filename: "/logs/app.log", ThresholdKB: 1, FS: m})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+		check(t, m)
+	})
+}
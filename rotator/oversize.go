@@ -0,0 +1,61 @@
+package rotator
+
+import "bytes"
+
+// An OversizePolicy selects what happens to a record longer than the
+// rotation threshold, which can't fit in a segment of its own.
+type OversizePolicy int
+
+const (
+	// OversizeOverflow writes the record whole, so that its segment exceeds
+	// the threshold. This is the default.
+	OversizeOverflow OversizePolicy = iota
+
+	// OversizeTruncate writes as much of the record as fits in a segment,
+	// ending it with " [truncated]", and drops the rest.
+	OversizeTruncate
+
+	// OversizeSplit breaks the record up into pieces that fit in a segment
+	// each, ending every piece but the last with " [continued]".
+	OversizeSplit
+)
+
+// Markers ending records shortened by OversizeTruncate and OversizeSplit.
+var (
+	truncatedMark = []byte(" [truncated]")
+	continuedMark = []byte(" [continued]")
+)
+
+// writeOversized writes rec, a record longer than the threshold, according
+// to the OversizePolicy. Each piece written starts a segment of its own.
+func (r *Rotator) writeOversized(rec []byte) error {
+	body := bytes.TrimSuffix(rec, []byte{r.delim})
+	for {
+		mark := continuedMark
+		if r.oversize == OversizeTruncate {
+			mark = truncatedMark
+		}
+		n := int(r.threshold) - len(mark) - 1
+		if n < 1 {
+			n = 1
+		}
+		if n >= len(body) {
+			n, mark = len(body), nil
+		}
+
+		if r.size > 0 {
+			if err := r.rotate("size"); err != nil {
+				return err
+			}
+		}
+		piece := append(body[:n:n], mark...)
+		if err := r.flush(append(piece, r.delim)); err != nil {
+			return err
+		}
+
+		body = body[n:]
+		if r.oversize == OversizeTruncate || len(body) == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package rotator
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// lookupOwner resolves Config.Owner and Config.Group, each a name or a
+// numeric ID, to a uid and gid. An empty one resolves to -1, which leaves it
+// unchanged.
+func lookupOwner(owner, group string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if owner != "" {
+		if uid, err = strconv.Atoi(owner); err != nil {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return 0, 0, err
+			}
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return 0, 0, errors.New("user " + owner + " has no numeric ID")
+			}
+		}
+	}
+	if group != "" {
+		if gid, err = strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return 0, 0, err
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return 0, 0, errors.New("group " + group + " has no numeric ID")
+			}
+		}
+	}
+	return uid, gid, nil
+}
+
+// withOwner wraps fsys so that every file it creates or opens for creation,
+// which includes the logfile, archives and their temporary files, is
+// given to uid and gid. The files it opens must have a Chown method, as
+// *os.File does.
+func withOwner(fsys FS, uid, gid int) FS {
+	o := &ownerFS{fsys, uid, gid}
+	if _, ok := fsys.(SymlinkFS); ok {
+		return &ownerSymlinkFS{o}
+	}
+	return o
+}
+
+// ownerFS implements withOwner.
+type ownerFS struct {
+	FS
+	uid, gid int
+}
+
+func (o *ownerFS) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	f, err := o.FS.OpenFile(name, flag, perm)
+	if err != nil || flag&os.O_CREATE == 0 {
+		return f, err
+	}
+	c, ok := f.(interface{ Chown(uid, gid int) error })
+	if !ok {
+		f.Close()
+		return nil, &fs.PathError{Op: "chown", Path: name, Err: errors.New("file system does not support ownership")}
+	}
+	if err := c.Chown(o.uid, o.gid); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// ownerSymlinkFS is an ownerFS whose underlying FS supports symlinks.
+type ownerSymlinkFS struct {
+	*ownerFS
+}
+
+func (o *ownerSymlinkFS) Symlink(oldname, newname string) error {
+	return o.FS.(SymlinkFS).Symlink(oldname, newname)
+}
+
+func (o *ownerSymlinkFS) Readlink(name string) (string, error) {
+	return o.FS.(SymlinkFS).Readlink(name)
+}
+
+func (o *ownerSymlinkFS) Lstat(name string) (fs.FileInfo, error) {
+	return o.FS.(SymlinkFS).Lstat(name)
+}
@@ -0,0 +1,56 @@
+package rotator
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// writePIDFile writes the current process ID to name. If name already holds
+// the ID of another running process, an error is returned and the file is
+// left alone; a PID file left behind by a process that is no longer running
+// is stale and gets replaced.
+//
+// The file is created exclusively, so that of two processes starting at
+// once, only one gets it.
+func writePIDFile(name string) error {
+	self := os.Getpid()
+	for tries := 0; ; tries++ {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(strconv.Itoa(self) + "\n")
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		}
+		if !os.IsExist(err) || tries == 10 {
+			return err
+		}
+
+		b, err := os.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if len(b) == 0 && tries < 5 {
+			// Another process may have just created it and be about to
+			// write its ID; if it stays empty, it is stale.
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err == nil && pid == self {
+			return nil
+		}
+		if err == nil && processExists(pid) {
+			return fmt.Errorf("%s: already held by running process %d", name, pid)
+		}
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+}
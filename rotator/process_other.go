@@ -0,0 +1,15 @@
+//go:build !unix
+
+package rotator
+
+import "os"
+
+// processExists reports whether a process with the given ID is running.
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,87 @@
+package rotator
+
+import "regexp"
+
+// RedactPatterns are built-in patterns for Config.Redact, by name.
+var RedactPatterns = map[string]*regexp.Regexp{
+	// email matches email addresses.
+	"email": regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+
+	// card matches runs of 13 to 19 digits, optionally grouped by spaces
+	// or dashes, as payment card numbers are written. Only runs that pass
+	// the Luhn check, as card numbers do, are masked, which spares most
+	// timestamps, IDs and other long numbers.
+	"card": regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+
+	// token matches bearer tokens, JWTs, AWS access key IDs and GitHub
+	// tokens.
+	"token": regexp.MustCompile(`(?i:\bbearer\s+[A-Za-z0-9._~+/-]+=*)` +
+		`|\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+` +
+		`|\bAKIA[0-9A-Z]{16}\b` +
+		`|\bgh[pousr]_[A-Za-z0-9]{36,}\b`),
+}
+
+// redactChecks are further checks that matches of built-in patterns must
+// pass to be masked, for what a regular expression can't express.
+var redactChecks = map[*regexp.Regexp]func([]byte) bool{
+	RedactPatterns["card"]: luhn,
+}
+
+// luhn reports whether the digits in b, ignoring anything else, pass the
+// Luhn check.
+func luhn(b []byte) bool {
+	sum, double := 0, false
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < '0' || b[i] > '9' {
+			continue
+		}
+		d := int(b[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// redactor masks the matches of a set of patterns in lines.
+type redactor struct {
+	patterns []*regexp.Regexp
+	mask     []byte
+}
+
+// newRedactor returns a redactor replacing matches of patterns with mask,
+// or "***" if mask is empty. It returns nil if there are no patterns.
+func newRedactor(patterns []*regexp.Regexp, mask string) *redactor {
+	if len(patterns) == 0 {
+		return nil
+	}
+	if mask == "" {
+		mask = "***"
+	}
+	return &redactor{patterns, []byte(mask)}
+}
+
+// redact returns line with every match masked. line itself is left
+// untouched.
+func (rd *redactor) redact(line []byte) []byte {
+	for _, re := range rd.patterns {
+		if !re.Match(line) {
+			continue
+		}
+		if check := redactChecks[re]; check != nil {
+			line = re.ReplaceAllFunc(line, func(m []byte) []byte {
+				if check(m) {
+					return rd.mask
+				}
+				return m
+			})
+		} else {
+			line = re.ReplaceAllLiteral(line, rd.mask)
+		}
+	}
+	return line
+}
@@ -0,0 +1,22 @@
+package rotator
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestRedactCard(t *testing.T) {
+	rd := newRedactor([]*regexp.Regexp{RedactPatterns["card"]}, "")
+	tests := []struct{ in, want string }{
+		{"paid with 4111 1111 1111 1111 today", "paid with *** today"},
+		{"paid with 4111-1111-1111-1111", "paid with ***"},
+		{"card=5500005555555559", "card=***"},
+		{"ts=1718000000123 id=4111111111111112", "ts=1718000000123 id=4111111111111112"},
+		{"order 1234567890123 shipped", "order 1234567890123 shipped"},
+	}
+	for _, tt := range tests {
+		if got := string(rd.redact([]byte(tt.in))); got != tt.want {
+			t.Errorf("redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package rotator
+
+import "os"
+
+// Reopen asks the Rotator to close the logfile and open it again by name,
+// without rotating. If another tool, such as logrotate(8), has moved the
+// logfile aside, writing then continues in a fresh file at the original
+// path. The reopen happens before the next line is written by Run or the
+// next call to Write.
+func (r *Rotator) Reopen() {
+	select {
+	case r.reopen <- struct{}{}:
+	default:
+	}
+}
+
+// Rotate asks the Rotator to rotate the logfile, if it isn't empty,
+// regardless of the threshold. Run rotates right away; a Rotator used only
+// through Write rotates before the next call to Write.
+func (r *Rotator) Rotate() {
+	select {
+	case r.rotateReq <- struct{}{}:
+	default:
+	}
+}
+
+// rotateIfRequested carries out a pending Rotate.
+func (r *Rotator) rotateIfRequested() error {
+	select {
+	case <-r.rotateReq:
+	default:
+		return nil
+	}
+	if r.size == 0 {
+		return nil
+	}
+	return r.rotate("manual")
+}
+
+// reopenIfRequested carries out a pending Reopen.
+func (r *Rotator) reopenIfRequested() error {
+	select {
+	case <-r.reopen:
+	default:
+		return nil
+	}
+	if err := r.writeHeld(); err != nil {
+		return err
+	}
+
+	f, err := openFile(r.fs, r.live, os.O_CREATE|os.O_APPEND|os.O_RDWR, r.mode)
+	if err != nil {
+		return err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.out.Close()
+	r.out = f
+	r.size = stat.Size()
+	return nil
+}
@@ -0,0 +1,35 @@
+package rotator
+
+import "testing"
+
+func TestReopen(t *testing.T) {
+	m := newMemFS("/logs")
+	r, err := NewWithConfig(nil, Config{Filename: "/logs/app.log", ThresholdKB: 1, FS: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("before\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// As logrotate(8) does, move the logfile aside and ask for a reopen.
+	if err := m.Rename("/logs/app.log", "/logs/app.log.old"); err != nil {
+		t.Fatal(err)
+	}
+	r.Reopen()
+	if _, err := r.Write([]byte("after\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	if got := string(m.read(t, "/logs/app.log.old")); got != "before\n" {
+		t.Errorf("moved logfile holds %q, want %q", got, "before\n")
+	}
+	if got := string(m.read(t, "/logs/app.log")); got != "after\n" {
+		t.Errorf("reopened logfile holds %q, want %q", got, "after\n")
+	}
+}
@@ -0,0 +1,202 @@
+package rotator
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Reorder configures a buffer that puts lines from several sources back in
+// the order of the timestamps they carry before they are written. Each line
+// is held for up to Window after it arrives, so that lines with earlier
+// timestamps arriving within that time are written before it. A line whose
+// timestamp is earlier than that of a line already written is written at
+// once, out of order, and reported to the ErrorLog. Lines without a usable
+// timestamp, such as the continuation lines of a stack trace, stay after
+// the line before them.
+type Reorder struct {
+	// Field and Layout locate and parse the timestamp of a line, as for
+	// LineTime.
+	Field  string
+	Layout string
+
+	// Window is how long lines are held. It should exceed the delay
+	// between sources, and adds as much latency to every line.
+	Window time.Duration
+
+	// MaxLines bounds the number of lines held; the earliest are written
+	// early once more arrive. It defaults to 10000.
+	MaxLines int
+}
+
+// heldLine is a line waiting in the reorder buffer.
+type heldLine struct {
+	ts      time.Time
+	seq     uint64 // keeps lines with equal timestamps in arrival order
+	arrived time.Time
+	rec     record
+}
+
+// lineHeap orders held lines by timestamp.
+type lineHeap []heldLine
+
+func (h lineHeap) Len() int { return len(h) }
+
+func (h lineHeap) Less(i, j int) bool {
+	if h[i].ts.Equal(h[j].ts) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].ts.Before(h[j].ts)
+}
+
+func (h lineHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *lineHeap) Push(x interface{}) { *h = append(*h, x.(heldLine)) }
+
+func (h *lineHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// reorderer implements Reorder.
+type reorderer struct {
+	Reorder
+	parse *lineTimer // only used to parse timestamps
+	held  lineHeap
+	seq   uint64
+	prev  time.Time // timestamp of the last line received
+	last  time.Time // timestamp of the last line released
+	late  int       // late lines not yet reported
+
+	flushC chan struct{} // closed by flush
+}
+
+func newReorderer(ro *Reorder) *reorderer {
+	if ro == nil || ro.Window <= 0 {
+		return nil
+	}
+	o := &reorderer{Reorder: *ro}
+	if o.MaxLines <= 0 {
+		o.MaxLines = 10000
+	}
+	o.parse = newLineTimer(&LineTime{Field: o.Field, Layout: o.Layout, Window: o.Window})
+	return o
+}
+
+// run passes the lines from in on to the returned channel, reordered. The
+// channel is closed once in is closed or flush is called and every held
+// line has been passed on, or when done is closed.
+func (o *reorderer) run(r *Rotator, in <-chan record, done <-chan struct{}) <-chan record {
+	out := make(chan record, cap(in))
+	o.flushC = make(chan struct{})
+	send := func(rec record) bool {
+		select {
+		case out <- rec:
+			return true
+		case <-done:
+			return false
+		}
+	}
+
+	// release passes on the held lines that are due, in order, or all of
+	// them if all is set.
+	release := func(all bool) bool {
+		now := time.Now()
+		for len(o.held) > 0 && (all || len(o.held) > o.MaxLines || !o.held[0].arrived.Add(o.Window).After(now)) {
+			h := heap.Pop(&o.held).(heldLine)
+			o.last = h.ts
+			if !send(h.rec) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// hold takes in a line, passing it on at once if it is too late to be
+	// reordered.
+	hold := func(rec record) bool {
+		ts, ok := o.parse.parse(rec.line)
+		if ok {
+			o.prev = ts
+		} else {
+			ts = o.prev
+		}
+		if ts.Before(o.last) {
+			o.late++
+			return send(rec)
+		}
+		o.seq++
+		heap.Push(&o.held, heldLine{ts, o.seq, time.Now(), rec})
+		return true
+	}
+
+	interval := o.Window / 4
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+
+	go func() {
+		defer close(out)
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case rec, ok := <-in:
+				if !ok {
+					o.reportLate(r)
+					release(true)
+					return
+				}
+				if !hold(rec) || !release(false) {
+					return
+				}
+
+			case <-tick.C:
+				o.reportLate(r)
+				if !release(false) {
+					return
+				}
+
+			case <-o.flushC:
+				// Take the lines already waiting on in, so that they
+				// are ordered along with the rest.
+				for waiting := true; waiting; {
+					select {
+					case rec, ok := <-in:
+						if !ok {
+							waiting = false
+						} else if !hold(rec) {
+							return
+						}
+					default:
+						waiting = false
+					}
+				}
+				o.reportLate(r)
+				release(true)
+				return
+
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// flush has run pass on every held line at once and close its channel,
+// without waiting for the rest of its input.
+func (o *reorderer) flush() {
+	close(o.flushC)
+}
+
+// reportLate logs the number of lines written out of order since the last
+// report.
+func (o *reorderer) reportLate(r *Rotator) {
+	if o.late > 0 {
+		r.logf("%d lines arrived too late to reorder and were written out of order", o.late)
+		o.late = 0
+	}
+}
@@ -0,0 +1,118 @@
+package rotator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reorderRun runs a Rotator reordering in by ro to the end, and returns
+// what it wrote and logged.
+func reorderRun(t *testing.T, ro Reorder, in string) (string, string) {
+	t.Helper()
+	m := newMemFS("/logs")
+	var logged bytes.Buffer
+	r, err := NewWithConfig(strings.NewReader(in), Config{
+		Filename:    "/logs/app.log",
+		ThresholdKB: 1 << 20,
+		Reorder:     &ro,
+		FS:          m,
+		ErrorLog:    log.New(&logged, "", 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+	return string(m.read(t, "/logs/app.log")), logged.String()
+}
+
+func TestReorder(t *testing.T) {
+	for _, tt := range []struct {
+		name, in, want string
+	}{
+		{
+			"Order",
+			"2026-10-15T08:00:03Z c\n2026-10-15T08:00:01Z a\n2026-10-15T08:00:02Z b\n",
+			"2026-10-15T08:00:01Z a\n2026-10-15T08:00:02Z b\n2026-10-15T08:00:03Z c\n",
+		},
+		{
+			"EqualTimes",
+			"2026-10-15T08:00:02Z b\n2026-10-15T08:00:01Z a\n2026-10-15T08:00:02Z c\n",
+			"2026-10-15T08:00:01Z a\n2026-10-15T08:00:02Z b\n2026-10-15T08:00:02Z c\n",
+		},
+		{
+			// Lines without a timestamp stay after the line before them.
+			"Unparseable",
+			"2026-10-15T08:00:02Z panic\n\tat main.go:12\n2026-10-15T08:00:01Z a\nnot a time\n",
+			"2026-10-15T08:00:01Z a\nnot a time\n2026-10-15T08:00:02Z panic\n\tat main.go:12\n",
+		},
+	} {
+		got, _ := reorderRun(t, Reorder{Window: time.Hour}, tt.in)
+		if got != tt.want {
+			t.Errorf("%s: logfile holds %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReorderMaxLines(t *testing.T) {
+	// The third line held releases the earliest, so the line after it is
+	// too late to be reordered.
+	in := "2026-10-15T08:00:05Z e\n2026-10-15T08:00:03Z c\n2026-10-15T08:00:04Z d\n2026-10-15T08:00:01Z a\n"
+	got, logged := reorderRun(t, Reorder{Window: time.Hour, MaxLines: 2}, in)
+	want := "2026-10-15T08:00:03Z c\n2026-10-15T08:00:01Z a\n2026-10-15T08:00:04Z d\n2026-10-15T08:00:05Z e\n"
+	if got != want {
+		t.Errorf("logfile holds %q, want %q", got, want)
+	}
+	if !strings.Contains(logged, "1 lines arrived too late") {
+		t.Errorf("logged %q, want the late line reported", logged)
+	}
+}
+
+func TestReorderShutdown(t *testing.T) {
+	defer func(d time.Duration) { ShutdownGrace = d }(ShutdownGrace)
+	ShutdownGrace = 100 * time.Millisecond
+
+	m := newMemFS("/logs")
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r, err := NewWithConfig(pr, Config{
+		Filename:    "/logs/app.log",
+		ThresholdKB: 1 << 20,
+		Reorder:     &Reorder{Window: time.Hour},
+		FS:          m,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.RunContext(ctx) }()
+
+	io.WriteString(pw, "2026-10-15T08:00:02Z two\n2026-10-15T08:00:01Z one\n2026-10-15T08:00:03Z thr")
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("RunContext returned %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	want := "2026-10-15T08:00:01Z one\n2026-10-15T08:00:02Z two\n2026-10-15T08:00:03Z thr\n"
+	if got := string(m.read(t, "/logs/app.log")); got != want {
+		t.Errorf("logfile holds %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,334 @@
+package rotator
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An Archive is a rotated segment of a logfile. It usually consists of a
+// single compressed file, along with its metadata and index if there are
+// any, but may briefly consist of more while it is being compressed.
+type Archive struct {
+	// Seq is the sequence number of the segment.
+	Seq int
+
+	// Files holds the paths of the files making up the segment.
+	Files []string
+
+	// Size is the combined size of Files.
+	Size int64
+
+	// ModTime is the latest modification time among Files, which is
+	// roughly the time the segment was rotated.
+	ModTime time.Time
+}
+
+// archives returns the existing segments, ordered from oldest to newest.
+func (nm *namer) archives() ([]Archive, error) {
+	entries, err := nm.fs.ReadDir(nm.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	bySeq := make(map[int]*Archive)
+	add := func(n int, info fs.FileInfo) {
+		a := bySeq[n]
+		if a == nil {
+			a = &Archive{Seq: n}
+			bySeq[n] = a
+		}
+		a.Files = append(a.Files, filepath.Join(nm.dir, info.Name()))
+		a.Size += info.Size()
+		if info.ModTime().After(a.ModTime) {
+			a.ModTime = info.ModTime()
+		}
+	}
+
+	// Sidecars are added once the segments and archives they belong to are
+	// known, so that a sidecar left on its own isn't taken for an archive.
+	var sidecars []fs.FileInfo
+	for _, e := range entries {
+		n, kind := nm.kind(e.Name())
+		if kind == notSegment {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if kind == sidecarFile {
+			sidecars = append(sidecars, info)
+			continue
+		}
+		add(n, info)
+	}
+	shared := make(map[int]int) // seq of a content-addressed segment -> seq owning its archive
+
+	if nm.index != "" {
+		cas, err := readCASIndex(nm.fs, nm.index)
+		if err != nil {
+			return nil, err
+		}
+		// Identical segments share an archive, which belongs to the newest
+		// of them so that it is kept as long as that one.
+		owner := make(map[string]int)
+		for _, c := range cas {
+			if c.seq > owner[c.name] {
+				owner[c.name] = c.seq
+			}
+		}
+		for _, c := range cas {
+			shared[c.seq] = owner[c.name]
+		}
+		for name, n := range owner {
+			info, err := nm.fs.Stat(filepath.Join(nm.dir, name))
+			if err != nil {
+				continue
+			}
+			add(n, info)
+		}
+	}
+
+	for _, info := range sidecars {
+		n, _ := nm.kind(info.Name())
+		if owner, ok := shared[n]; ok {
+			n = owner
+		}
+		if bySeq[n] != nil {
+			add(n, info)
+		}
+	}
+
+	arcs := make([]Archive, 0, len(bySeq))
+	for _, a := range bySeq {
+		arcs = append(arcs, *a)
+	}
+	sort.Slice(arcs, func(i, j int) bool { return arcs[i].Seq < arcs[j].Seq })
+	return arcs, nil
+}
+
+// retention decides which archives are deleted.
+type retention struct {
+	keep      int
+	keepDaily int
+	maxAge    time.Duration
+	maxTotal  int64
+}
+
+func (p retention) enabled() bool {
+	return p.keep > 0 || p.keepDaily > 0 || p.maxAge > 0 || p.maxTotal > 0
+}
+
+// expired returns the archives in arcs, which are ordered from oldest to
+// newest, that are no longer to be kept as of now, given that the live
+// logfile takes up live bytes. An archive is expired if any of the policies
+// set expires it.
+func (p retention) expired(arcs []Archive, live int64, now time.Time) []Archive {
+	drop := make([]bool, len(arcs))
+
+	if p.keepDaily > 0 {
+		newest := make(map[int]int) // days ago -> seq of the day's newest archive
+		for _, a := range arcs {
+			newest[daysBetween(a.ModTime, now)] = a.Seq
+		}
+		for i, a := range arcs {
+			days := daysBetween(a.ModTime, now)
+			if days > p.keepDaily || days > 0 && newest[days] != a.Seq {
+				drop[i] = true
+			}
+		}
+	}
+
+	if p.keep > 0 {
+		for i := 0; i < len(arcs)-p.keep; i++ {
+			drop[i] = true
+		}
+	}
+
+	if p.maxAge > 0 {
+		for i, a := range arcs {
+			if now.Sub(a.ModTime) > p.maxAge {
+				drop[i] = true
+			}
+		}
+	}
+
+	if p.maxTotal > 0 {
+		total := live
+		for i, a := range arcs {
+			if !drop[i] {
+				total += a.Size
+			}
+		}
+		for i := 0; i < len(arcs) && total > p.maxTotal; i++ {
+			if !drop[i] {
+				drop[i] = true
+				total -= arcs[i].Size
+			}
+		}
+	}
+
+	var out []Archive
+	for i, a := range arcs {
+		if drop[i] {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// daysBetween returns the number of calendar days from t to now in the local
+// time zone.
+func daysBetween(t, now time.Time) int {
+	y, m, d := t.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	y, m, d = now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	return int(today.Sub(day).Round(24*time.Hour) / (24 * time.Hour))
+}
+
+// setInflight records whether the archive with sequence number seq is being
+// compressed.
+func (r *Rotator) setInflight(seq int, busy bool) {
+	r.inflightMu.Lock()
+	defer r.inflightMu.Unlock()
+	if busy {
+		r.inflight[seq] = true
+	} else {
+		delete(r.inflight, seq)
+	}
+}
+
+// isInflight reports whether the archive with sequence number seq is being
+// compressed.
+func (r *Rotator) isInflight(seq int) bool {
+	r.inflightMu.Lock()
+	defer r.inflightMu.Unlock()
+	return r.inflight[seq]
+}
+
+// prune deletes the archives that the retention policy no longer keeps,
+// sparing those still being compressed or younger than PruneGrace.
+// The caller must hold r.archiveMu.
+func (r *Rotator) prune() error {
+	arcs, err := r.naming.archives()
+	if err != nil {
+		return err
+	}
+
+	var live int64
+	if r.retention.maxTotal > 0 {
+		name := r.live
+		if name == "" {
+			name = r.filename
+		}
+		if info, err := r.fs.Stat(name); err == nil {
+			live = info.Size()
+		}
+	}
+
+	now := time.Now()
+	for _, a := range r.retention.expired(arcs, live, now) {
+		if r.isInflight(a.Seq) || now.Sub(a.ModTime) < r.pruneGrace {
+			continue
+		}
+		for _, name := range a.Files {
+			if err := r.fs.Remove(name); err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				return err
+			}
+			r.emit(Event{Type: Pruned, Archive: name})
+		}
+	}
+	if r.naming.index != "" {
+		return r.pruneCASIndex()
+	}
+	return nil
+}
+
+// Archives returns the existing archives, ordered from oldest to newest.
+func (r *Rotator) Archives() ([]Archive, error) {
+	return r.naming.archives()
+}
+
+// Archives returns the existing archives of the logfile cfg.Filename, as
+// named according to cfg, ordered from oldest to newest. Unlike
+// NewWithConfig, it leaves the logfile alone.
+func Archives(cfg Config) ([]Archive, error) {
+	r, err := archiveRotator(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return r.Archives()
+}
+
+// Prune deletes the archives of the logfile cfg.Filename that the retention
+// policy set in cfg no longer keeps, and updates the manifest if
+// cfg.Manifest is set, as a Rotator does after each rotation. Unlike
+// NewWithConfig, it leaves the logfile alone.
+func Prune(cfg Config) error {
+	r, err := archiveRotator(cfg)
+	if err != nil {
+		return err
+	}
+
+	r.archiveMu.Lock()
+	defer r.archiveMu.Unlock()
+	if r.retention.enabled() {
+		if err := r.prune(); err != nil {
+			return err
+		}
+	}
+	if r.manifest {
+		return r.writeManifest()
+	}
+	return nil
+}
+
+// archiveRotator returns a Rotator that is only fit for managing the
+// archives of cfg.Filename.
+func archiveRotator(cfg Config) (*Rotator, error) {
+	fsys := cfg.FS
+	if fsys == nil {
+		fsys = osFS{}
+	}
+	naming, err := newNamer(fsys, cfg.Filename, cfg.Naming, cfg.NamingSep, cfg.NamingTimeLayout)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ContentAddressed {
+		naming.index = casIndexName(cfg.Filename)
+	}
+	ext := strings.TrimPrefix(cfg.CompressExt, ".")
+	if ext == "" && cfg.Compressor != nil {
+		ext = cfg.Compressor.Ext()
+	}
+	naming.exts = archiveExts(ext)
+	r := &Rotator{
+		filename:   cfg.Filename,
+		fs:         fsys,
+		naming:     naming,
+		manifest:   cfg.Manifest,
+		inflight:   make(map[int]bool),
+		pruneGrace: cfg.PruneGrace,
+		retention: retention{
+			keep:      cfg.Keep,
+			keepDaily: cfg.KeepDaily,
+			maxAge:    cfg.MaxAge,
+			maxTotal:  cfg.MaxTotalSize,
+		},
+		dictFile: cfg.ZstdDict,
+	}
+	if r.dictFile != "" {
+		if err := r.loadDict(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
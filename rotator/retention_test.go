@@ -0,0 +1,54 @@
+package rotator
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+)
+
+// TestPruneStress rotates far faster than archives can be compressed and
+// pruned, to catch prune removing a segment or archive that a compression
+// still needs.
+func TestPruneStress(t *testing.T) {
+	m := newMemFS("/logs")
+	var errs bytes.Buffer
+	line := strings.Repeat("x", 99) + "\n"
+	input := strings.Repeat(line, 2000)
+	r, err := NewWithConfig(strings.NewReader(input), Config{
+		Filename:    "/logs/app.log",
+		ThresholdKB: 1,
+		Keep:        2,
+		FS:          m,
+		ErrorLog:    log.New(&errs, "", 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	if errs.Len() > 0 {
+		t.Errorf("errors logged:\n%s", errs.Bytes())
+	}
+	names := m.names("/logs")
+	if len(names) != 3 {
+		t.Fatalf("got files %v, want the logfile and 2 archives", names)
+	}
+	last, _ := r.naming.last()
+	for i, name := range names[1:] {
+		if want := fmt.Sprintf("app.log.%d.gz", last-1+i); name != want {
+			t.Errorf("got archive %s, want %s", name, want)
+		}
+		if got := gunzip(t, m.read(t, "/logs/"+name)); !bytes.Equal(got, []byte(strings.Repeat(line, len(got)/len(line)))) || len(got) == 0 {
+			t.Errorf("%s holds %d bytes that aren't whole lines", name, len(got))
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package rotator
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+const (
+	// maxRetryQueue is the number of failed steps remembered for retrying.
+	// Beyond it, the oldest are forgotten.
+	maxRetryQueue = 64
+
+	// maxRetries is how often a failed step is retried.
+	maxRetries = 5
+
+	// retryBackoff is the least time before the first retry of a failed
+	// step. It doubles with each further attempt.
+	retryBackoff = 30 * time.Second
+)
+
+// A retryStep is a step in archiving a rotated segment that can fail and be
+// retried on its own.
+type retryStep int
+
+const (
+	stepCompress retryStep = iota
+	stepUpload
+	stepOnArchive
+)
+
+// A compressJob describes the compression of a rotated segment, or a later
+// step in archiving it.
+type compressJob struct {
+	seq       int
+	segment   string
+	appending bool
+	rotated   time.Time
+
+	step    retryStep
+	archive string // the archive made of segment, once there is one
+
+	attempts int
+	next     time.Time // earliest time of the next attempt
+}
+
+// describe returns what job does, for messages.
+func (job compressJob) describe() string {
+	switch job.step {
+	case stepUpload:
+		return "uploading " + job.archive
+	case stepOnArchive:
+		return "OnArchive for " + job.archive
+	}
+	return "compressing " + job.segment
+}
+
+// queueRetry schedules a failed step to be retried, unless it has failed too
+// often already.
+func (r *Rotator) queueRetry(job compressJob) {
+	job.attempts++
+	if job.attempts > maxRetries {
+		r.logf("giving up on %s after %d attempts", job.describe(), job.attempts)
+		return
+	}
+	job.next = time.Now().Add(retryBackoff << (job.attempts - 1))
+
+	r.retryMu.Lock()
+	defer r.retryMu.Unlock()
+	if len(r.retryQ) >= maxRetryQueue {
+		r.logf("too many failed steps; no longer retrying %s", r.retryQ[0].describe())
+		r.retryQ = r.retryQ[1:]
+	}
+	r.retryQ = append(r.retryQ, job)
+}
+
+// retryFailed retries the failed steps that are due. It is called after
+// every rotation's compression, so retries happen no sooner than the next
+// rotation after their backoff has passed.
+func (r *Rotator) retryFailed() {
+	now := time.Now()
+	var due []compressJob
+
+	r.retryMu.Lock()
+	keep := r.retryQ[:0]
+	for _, job := range r.retryQ {
+		if now.Before(job.next) {
+			keep = append(keep, job)
+		} else {
+			due = append(due, job)
+		}
+	}
+	r.retryQ = keep
+	r.retryMu.Unlock()
+
+	for _, job := range due {
+		switch job.step {
+		case stepUpload:
+			err := r.put(job.archive)
+			if err == nil {
+				r.hookArchive(job, job.archive)
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				r.logf("%s: %v", job.describe(), err)
+				r.queueRetry(job)
+			}
+			continue
+		case stepOnArchive:
+			if err := r.callOnArchive(job.segment, job.archive); err != nil {
+				r.logf("%s: %v", job.describe(), err)
+				r.queueRetry(job)
+			}
+			continue
+		}
+		src, err := r.fs.OpenFile(job.segment, os.O_RDONLY, 0)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Someone else has dealt with it.
+			continue
+		}
+		if err == nil {
+			r.setInflight(job.seq, true)
+			err = r.archive(src, job)
+			r.setInflight(job.seq, false)
+		}
+		if err != nil {
+			r.queueRetry(job)
+		}
+	}
+}
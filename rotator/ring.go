@@ -0,0 +1,55 @@
+package rotator
+
+import (
+	"os"
+	"time"
+)
+
+// lastSlot returns the ring slot holding the newest segment, or 0 if no slot
+// is in use yet.
+func (r *Rotator) lastSlot() int {
+	slot := 0
+	var newest time.Time
+	for n := 1; n <= r.ring; n++ {
+		info, err := r.fs.Stat(r.naming.format(n))
+		if err != nil {
+			continue
+		}
+		if slot == 0 || info.ModTime().After(newest) {
+			slot, newest = n, info.ModTime()
+		}
+	}
+	return slot
+}
+
+// rotateRing moves the logfile into the slot after the one last used,
+// overwriting the oldest segment once all slots are in use. Segments in the
+// ring are neither compressed nor pruned.
+func (r *Rotator) rotateRing(reason string) error {
+	slot := r.ringSlot%r.ring + 1
+	name := r.naming.format(slot)
+	if err := r.fs.Rename(r.live, name); err != nil {
+		return err
+	}
+	f, err := openFile(r.fs, r.live, os.O_CREATE|os.O_RDWR, r.mode)
+	if err != nil {
+		// Put the logfile back, so that writing carries on in it.
+		r.fs.Rename(name, r.live)
+		return err
+	}
+	if r.meta {
+		if err := r.writeMeta(name, slot, false, reason); err != nil {
+			r.logf("writing metadata of %s: %v", name, err)
+		}
+	}
+	r.out.Close()
+	r.out = f
+	r.size = 0
+	r.opened = time.Now()
+	r.nRotated.Add(1)
+	r.lineNo = 0
+	r.first, r.last = time.Time{}, time.Time{}
+	r.ringSlot = slot
+	r.emit(Event{Type: RotationStarted, Segment: name})
+	return nil
+}
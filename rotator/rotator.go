@@ -1,160 +1,2204 @@
 // package rotator implements a simple logfile rotator. Logs are read from an
 // io.Reader and are written to a file until they reach a specified size. The
-// log is then gzipped to another file and truncated.
+// log is then compressed (with gzip, by default) to another file and
+// truncated.
 package rotator
 
 import (
 	"bufio"
-	"compress/gzip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// FreeSpaceInterval is how often the free space on the logfile's volume is
+// checked when Config.MinFreeSpace is set.
+var FreeSpaceInterval = 10 * time.Second
+
+// ShutdownGrace is how long RunContext keeps reading its inputs once its
+// context is done, for them to reach the end of their input, before writing
+// whatever line each is in the middle of as it is.
+var ShutdownGrace = time.Second
+
+// ErrRotationLimit is returned by Run when Config.MaxRotationsPerMin is
+// exceeded and Config.FailOnRotationLimit is set.
+var ErrRotationLimit = errors.New("too many rotations per minute")
+
+// ErrNoInput is returned by Run when Config.InputTimeout passes without any
+// input arriving.
+var ErrNoInput = errors.New("no input received")
+
+// maxBatch is the size in bytes beyond which no further lines are added to a
+// single write.
+const maxBatch = 64 * 1024
+
+// maxHeld is how much output FlushOnRotate holds back before writing it
+// anyway.
+const maxHeld = 1 << 20
+
+// DefaultFlushSize is the default Config.FlushSize.
+const DefaultFlushSize = 64 * 1024
+
+// DefaultMaxLineSize is the default Config.MaxLineSize.
+const DefaultMaxLineSize = 64 << 20
+
 // A Rotator reads log lines from an input source and writes them to a file,
 // splitting it up into gzipped chunks once the filesize reaches a certain
 // threshold.
 type Rotator struct {
-	size      int64
-	threshold int64
-	filename  string
-	in        *bufio.Scanner
-	out       *os.File
-	tee       bool
-	wg        sync.WaitGroup
+	size       int64
+	threshold  int64
+	sizePct    float64
+	filename   string
+	live       string
+	inputs     []input
+	out        File
+	fs         FS
+	delim      byte
+	tee        bool
+	teePrimary bool
+	pidfile    string
+	minFree    int64
+	naming     *namer
+	buf        []byte
+	over       []byte
+	oversize   OversizePolicy
+	maxLine    int
+	comp       Compressor
+	ext        string
+	idle       time.Duration
+	segAge     time.Duration
+	every      time.Duration
+	nextClock  time.Time // when Write next rotates for RotateEvery
+	inTimeout  time.Duration
+	opened     time.Time
+	jitter     time.Duration
+	jitterOff  time.Duration
+	jitterEach bool
+	minSize    int64
+	retention  retention
+	writeMu    sync.Mutex // serializes Write and Close
+	closed     bool       // set by Close, under writeMu
+	archiveMu  sync.Mutex
+	inflightMu sync.Mutex
+	inflight   map[int]bool
+	retryMu    sync.Mutex
+	retryQ     []compressJob
+	pruneGrace time.Duration
+	manifest   bool
+	coalesce   int64
+	health     health
+	tempDir    string
+	zTimeout   time.Duration
+	zMinSize   int64
+	zNone      bool
+	verify     bool
+	addressed  bool
+	keepPlain  bool
+	tags       *tagger
+	redact     *redactor
+	retries    int
+	retryDelay time.Duration
+	lineTime   *lineTimer
+	reorder    *reorderer
+	sealOnExit bool
+	ring       int
+	dailyTar   bool
+	keepPrev   bool
+	headerName string
+	hold       bool
+	held       []byte
+	holdMax    int
+	durable    bool
+	flushInt   time.Duration
+	flushT     *time.Timer // pending flush of output held by Write
+	flushReq   chan struct{}
+	syncPol    SyncPolicy
+	unsynced   int64       // bytes written since the last sync under syncPol
+	syncT      *time.Timer // pending sync of output written by Write
+	running    atomic.Bool
+	reopen     chan struct{}
+	rotateReq  chan struct{}
+	ringSlot   int
+	mode       os.FileMode
+	owner      string
+	group      string
+	events     chan Event
+	eventsMu   sync.RWMutex // guards sends on events against Close
+	evClosed   bool
+	dropped    atomic.Uint64
+	policy     WritePolicy
+	failWrite  bool
+	copyTrunc  bool
+	sink       ArchiveSink
+	rmUploaded bool
+	onArchive  func(ctx context.Context, segment, archive string) error
+	dropBytes  atomic.Uint64
+	dropLines  atomic.Uint64
+	nLines     atomic.Uint64
+	nBytes     atomic.Uint64
+	nRotated   atomic.Uint64
+	nZipped    atomic.Uint64
+	zNanos     atomic.Int64
+	marker     string
+	trailer    string
+	meta       bool
+	first      time.Time // when the segment was first written to
+	last       time.Time // when the segment was last written to
+	collide    CollisionPolicy
+	numWidth   int
+	sentinel   *regexp.Regexp
+	sentAt     SentinelPosition
+	lineNo     int
+	maxRotPM   int
+	failOnMax  bool
+	recentRot  []time.Time
+	limited    bool
+	errorLog   *log.Logger
+	zSlots     chan struct{} // one per pending compression, if limited
+	zBehind    bool
+	zTarget    int64
+	dictFile   string        // where the zstd dictionary is kept
+	dictTrain  int           // number of segments to train a dictionary on
+	zDict      []byte        // zstd dictionary, once there is one
+	samples    [][]byte      // samples for training a dictionary
+	sampled    int           // number of segments sampled
+	zRatio     float64       // moving average compression ratio
+	nextThr    atomic.Int64  // threshold adapted to zTarget, if set
+	zChain     chan struct{} // closed once the last compression started is done
+	wg         sync.WaitGroup
+}
+
+// Config holds the settings used to construct a Rotator.
+type Config struct {
+	// Filename is the path of the active logfile.
+	Filename string
+
+	// ThresholdKB is the size in kilobytes at which the logfile is rotated.
+	// Exactly one of ThresholdKB and ThresholdPercent must be positive.
+	ThresholdKB int64
+
+	// ThresholdPercent sets the rotation threshold to this percentage of the
+	// capacity of the logfile's volume instead, so that one setting suits
+	// hosts with different disk sizes. The capacity is determined at startup
+	// and again every FreeSpaceInterval. It is not supported on platforms
+	// where the capacity cannot be determined.
+	ThresholdPercent float64
+
+	// TargetArchiveSize, if positive, adjusts the threshold after each
+	// archive is made so that archives come out close to this size in bytes
+	// once compressed, going by a moving average of recent compression
+	// ratios. The threshold given by ThresholdKB is the first guess. It
+	// cannot be combined with ThresholdPercent.
+	TargetArchiveSize int64
+
+	// Oversize selects what happens to a line read from the input that is
+	// longer than the threshold on its own: OversizeOverflow, the default,
+	// OversizeTruncate or OversizeSplit.
+	Oversize OversizePolicy
+
+	// CurrentSuffix, if set, is appended to Filename to name the file
+	// actually written to, such as "app.log.current", while Filename is
+	// made a symbolic link to it for compatibility. This way the live file
+	// is never confused with archives by name. A regular file at Filename,
+	// left over from running without CurrentSuffix, becomes the live file.
+	// It requires the FS to be a SymlinkFS.
+	CurrentSuffix string
+
+	// Tee causes every line to be copied to standard output as well.
+	Tee bool
+
+	// TeePrimary is like Tee, but makes standard output the primary
+	// destination and the logfile a rotated backup copy, as suits containers
+	// whose runtime collects standard output. Lines are written to standard
+	// output first, and a failure to do so ends Run, or is returned by
+	// Write. Failures to write the logfile are only reported by Healthy.
+	TeePrimary bool
+
+	// PIDFile, if set, is the path of a file that holds the current process
+	// ID for the lifetime of the Rotator. It is removed by Close.
+	PIDFile string
+
+	// MinFreeSpace, if positive, forces a rotation whenever the free space
+	// on the logfile's volume drops below this many bytes, so that the old
+	// segment is compressed and can be cleaned up. It has no effect on
+	// platforms where free space cannot be determined.
+	MinFreeSpace int64
+
+	// Naming selects how rotated segments are named: NamingSuffix (the
+	// default), NamingInfix, NamingTimestamp, or a template as described
+	// for those constants. Names that include the rotation time cannot be
+	// combined with Ring, CoalesceBelow, AdoptReversed or ContentAddressed,
+	// which derive the names of segments from their numbers alone.
+	Naming string
+
+	// NamingSep is the separator placed before the sequence number by the
+	// built-in naming schemes. It defaults to ".".
+	NamingSep string
+
+	// NamingTimeLayout is the Go time layout of {time} in naming
+	// templates. It defaults to DefaultNamingTimeLayout.
+	NamingTimeLayout string
+
+	// Compressor compresses rotated segments. It defaults to Gzip with the
+	// default compression level.
+	Compressor Compressor
+
+	// CompressExt overrides the extension given to archives, which is
+	// normally the Compressor's, for downstream tools that expect a
+	// particular name such as "gz.archive".
+	CompressExt string
+
+	// IdleTimeout, if positive, rotates the logfile once no input has
+	// arrived for this long, so that the last segment of a burst is sealed
+	// promptly rather than when the next burst fills it up.
+	IdleTimeout time.Duration
+
+	// SegmentTrailer, if set, is written to the logfile as a line of its
+	// own right before each rotation, so that a reader can tell a complete
+	// segment from one cut short by a crash. The placeholders {time},
+	// {seq}, {lines} and {bytes} are replaced by the time, the sequence
+	// number the segment is rotated to, and the number of lines and bytes
+	// in the segment before the trailer. Lines passed to Write are not
+	// counted.
+	SegmentTrailer string
+
+	// SegmentMeta writes a metadata file for each segment as it is rotated,
+	// named like the segment with ".meta" appended (as in app.log.1.meta),
+	// so that archives can be picked out without decompressing them. It
+	// holds a JSON object with the segment's sequence number, its number of
+	// lines and bytes, when it was opened, first and last written to, and
+	// rotated, and the reason it was rotated: size, line-time, match, idle,
+	// age, schedule, free-space, manual, start or exit. The metadata of
+	// segments coalesced into one archive is combined. It is replaced
+	// atomically, and removed with the archive by retention. It cannot be
+	// combined with KeepPrev or DailyTar.
+	SegmentMeta bool
+
+	// ShutdownMarker, if set, is written to the logfile as a line of its
+	// own by Close, so that a segment that ended cleanly can be told from
+	// one cut short by a crash. The placeholders {time}, {lines}, {bytes}
+	// and {rotations} are replaced by the time and the counters of Stats.
+	// See DefaultShutdownMarker.
+	ShutdownMarker string
+
+	// InputTimeout, if positive, makes Run return ErrNoInput if no input at
+	// all has arrived this long after it started, such as when the producer
+	// died at startup. Once input has arrived, it no longer applies; see
+	// IdleTimeout for input that stops.
+	InputTimeout time.Duration
+
+	// MaxSegmentAge, if positive, rotates the logfile once it has been
+	// written to for this long, however small it is and whether or not
+	// input is still arriving, so that no segment stays open for longer.
+	// Empty logfiles are left alone.
+	MaxSegmentAge time.Duration
+
+	// RotateEvery, if positive, rotates the logfile whenever the local time
+	// reaches a multiple of RotateEvery since midnight, whether or not it
+	// has reached the threshold: 24h rotates at midnight, 1h on the hour.
+	// Intervals that don't divide a day are restarted at each midnight, and
+	// ones longer than a day are rounded down to whole days counted from
+	// startup. Without Run, the rotation happens at the first Write once
+	// the time has come.
+	RotateEvery time.Duration
+
+	// MinSize is the size in bytes that the logfile must have reached for a
+	// time-triggered rotation, such as IdleTimeout, to take place. Empty
+	// logfiles are never rotated by time.
+	MinSize int64
+
+	// PruneGrace is how old an archive must be before the retention policy
+	// may delete it, measured from its modification time. Archives still
+	// being compressed are never deleted, regardless.
+	PruneGrace time.Duration
+
+	// KeepDaily, if positive, thins out archives from before today to the
+	// newest one of each calendar day, and deletes those rotated more than
+	// KeepDaily days ago. The day an archive belongs to is taken from its
+	// modification time.
+	KeepDaily int
+
+	// Keep, if positive, deletes the oldest archives once there are more
+	// than Keep of them. It may be combined with KeepDaily and MaxAge, in
+	// which case archives are deleted as soon as any policy allows.
+	Keep int
+
+	// MaxAge, if positive, deletes archives rotated more than MaxAge ago,
+	// as told by their modification time.
+	MaxAge time.Duration
+
+	// MaxTotalSize, if positive, deletes the oldest archives while the
+	// archives and the live logfile together take up more than
+	// MaxTotalSize bytes, so that a burst of output can't fill the volume
+	// however few archives there are. Archives spared by PruneGrace or
+	// still being compressed still count towards it.
+	MaxTotalSize int64
+
+	// Mode is the permission bits given to the logfile and its archives. It
+	// is applied with an explicit chmod, so it is not subject to the umask.
+	// It defaults to 0644.
+	Mode os.FileMode
+
+	// Owner and Group, if set, are the user and group, by name or numeric
+	// ID, given the logfile, its archives and the other files created
+	// beside it, such as when the consuming service runs as another user.
+	// Changing the owner generally requires running as root. They are not
+	// supported on Windows, nor by an FS whose files lack a Chown method.
+	Owner string
+	Group string
+
+	// RotateOnStart rotates an existing, non-empty logfile as soon as the
+	// Rotator is created, so that each run begins with a fresh logfile.
+	// Otherwise an existing logfile is appended to, and its size counts
+	// towards the threshold, so a restart continues the segment in progress.
+	RotateOnStart bool
+
+	// NoAppend is like RotateOnStart, but guarantees that the Rotator never
+	// appends to a logfile left over from a previous run: if the logfile
+	// is not empty once its existing content has been archived,
+	// NewWithConfig fails instead. Segment boundaries then depend only on
+	// what this run wrote. Sequence numbers still continue after those of
+	// the existing archives, which would otherwise be overwritten.
+	NoAppend bool
+
+	// Jitter, if positive, delays time-triggered rotations by a random
+	// amount of up to this long, so that a fleet of processes configured
+	// alike doesn't rotate and compress all at once. The delay is chosen
+	// once per Rotator unless JitterEach is set, in which case it is chosen
+	// afresh for each rotation. Size-triggered rotations are not delayed.
+	Jitter     time.Duration
+	JitterEach bool
+
+	// Inputs are further sources of lines, read concurrently with the
+	// reader passed to NewWithConfig. Each line is written whole, so lines
+	// from different inputs never interleave.
+	Inputs []Input
+
+	// Split, if set, is used to split the input into lines in place of
+	// bufio.ScanLines, such as ScanLinesCR. A newline is appended to each
+	// line written unless it already ends with one, so split functions that
+	// keep the delimiter work as expected. Lines are read whole up to
+	// MaxLineSize; see Oversize for what happens to those longer than the
+	// threshold.
+	Split bufio.SplitFunc
+
+	// MaxLineSize is the most of a line read from the input that is kept,
+	// so that input without delimiters can't exhaust memory. The rest of a
+	// longer line is dropped, and counted in Stats.DroppedBytes. It
+	// defaults to DefaultMaxLineSize.
+	MaxLineSize int
+
+	// Delimiter, if set, is the single byte that terminates records in
+	// place of a newline, both when splitting the input and when writing
+	// the logfile; "\x00" handles the output of find -print0 and the like.
+	Delimiter string
+
+	// MaxRotationsPerMin, if positive, is a circuit breaker against
+	// misconfiguration: once this many rotations have happened within the
+	// last minute, further rotations are refused and the logfile is left to
+	// grow until the rate drops. If FailOnRotationLimit is set, Run returns
+	// ErrRotationLimit instead.
+	MaxRotationsPerMin  int
+	FailOnRotationLimit bool
+
+	// Manifest maintains a JSON index of the archives next to the logfile,
+	// named like the logfile with ".index.json" appended, so that collectors
+	// can find archives without scanning the directory. It is rewritten
+	// after every compression and prune.
+	Manifest bool
+
+	// ArchiveHeader records the logfile's base name and the time of the
+	// rotation in the header of each archive, for compressors that
+	// implement HeaderCompressor such as Gzip. Otherwise both are left
+	// empty, so that identical segments give byte-identical archives.
+	ArchiveHeader bool
+
+	// FlushOnRotate trades durability for fewer system calls: output is
+	// held in memory, up to a megabyte at a time, rather than written as
+	// lines arrive, and the logfile is only written out and synced to disk
+	// right before each rotation, and by Close. Every archive is thus made
+	// from a complete, durable segment, while a crash loses at most the
+	// output held back for the current segment. The logfile's directory
+	// is synced after each rotation too, so that the renames survive a
+	// crash along with the data.
+	FlushOnRotate bool
+
+	// FlushInterval, if positive, holds output in memory like
+	// FlushOnRotate, but writes it out at least this often, and whenever
+	// FlushSize bytes are held, saving system calls under heavy input while
+	// a crash loses at most about this much time's worth of output. Unlike
+	// FlushOnRotate, it doesn't sync. Flush writes held output right away.
+	FlushInterval time.Duration
+
+	// FlushSize is how much output FlushInterval holds before writing it
+	// regardless. It defaults to DefaultFlushSize.
+	FlushSize int
+
+	// Sync selects when the logfile is synced to disk. The logfile is
+	// also synced before each rotation and by Close if the policy is set.
+	Sync SyncPolicy
+
+	// CompressMinSize, if positive, leaves rotated segments smaller than
+	// this many bytes uncompressed, as compressing them costs more than it
+	// saves. Such segments keep their numbered name without the archive
+	// extension, and are otherwise treated like archives. Segments appended
+	// to an archive by CoalesceBelow or DailyTar are always compressed.
+	CompressMinSize int64
+
+	// NoCompression leaves every rotated segment uncompressed, as
+	// CompressMinSize does for small ones, for consumers that can't read
+	// compressed files. It cannot be combined with CoalesceBelow or
+	// DailyTar.
+	NoCompression bool
+
+	// VerifyArchives reads back each new archive and checks that it
+	// decompresses to the rotated segment before the segment is removed.
+	// An archive that fails the check is removed instead, and compressing
+	// the segment is retried later. It requires a Decompressor, and does not
+	// apply to CoalesceBelow or DailyTar.
+	VerifyArchives bool
+
+	// KeepUncompressed keeps each rotated segment next to its archive
+	// instead of removing it once compressed. Retention treats both as one
+	// archive.
+	KeepUncompressed bool
+
+	// OnCollision selects what happens when a rotated segment's archive
+	// already exists. It defaults to CollisionOverwrite.
+	OnCollision CollisionPolicy
+
+	// MaxPendingCompressions, if positive, limits the number of rotated
+	// segments waiting to be compressed. Once the limit is reached, the
+	// next rotation waits for a compression to finish, which stops input
+	// from being read (and Write from returning) until compression catches
+	// up, rather than letting uncompressed segments fill the disk.
+	MaxPendingCompressions int
+
+	// ZstdDict is the path of a zstd dictionary for the Zstd compressor to
+	// compress archives with, as trained by zstd --train. Unless ZstdTrain
+	// is set, it must exist. The manifest records it, along with its ID,
+	// and the ID with each archive compressed with it.
+	ZstdDict string
+
+	// ZstdTrain, if positive and ZstdDict doesn't exist yet, trains a
+	// dictionary on the first ZstdTrain segments, writes it to ZstdDict and
+	// compresses later archives with it. Archives compressed before then
+	// don't use it.
+	ZstdTrain int
+
+	// CompressTimeout, if positive, bounds the time spent compressing a
+	// rotated segment, so that a stalled disk can't hold up Close forever.
+	// When it is exceeded, the partial archive is removed and the segment
+	// is left uncompressed, to be retried like any failed compression. It
+	// bounds each upload to the Sink and each call of OnArchive the same
+	// way.
+	CompressTimeout time.Duration
+
+	// CoalesceBelow, if positive, appends each rotated segment to the most
+	// recent archive rather than starting a new one, for as long as that
+	// archive is smaller than this many bytes. This keeps the number of
+	// files down for quiet logs. It requires an AppendableCompressor.
+	CoalesceBelow int64
+
+	// TempDir, if set, is the directory in which archives are written
+	// before being moved next to the logfile, for instance to keep
+	// intermediate files on a fast local volume. Temporary files are private
+	// to the owner and removed whether or not compression succeeds. By
+	// default archives are written in place.
+	TempDir string
+
+	// Tags are added to every line read from the input, to make the
+	// logfile self-describing once collected elsewhere. Lines holding a JSON
+	// object get the tags as extra members of the object; other lines are
+	// prefixed with them as key=value pairs. Data passed to Write is not
+	// tagged.
+	Tags []Tag
+
+	// RotateOn, if set, rotates the logfile whenever a line read from the
+	// input matches it, such as a marker line that a producer writes at the
+	// end of each batch, so that segments follow the producer's own
+	// boundaries. RotateOnAt selects whether the matching line ends a
+	// segment, starts one, or is dropped. The line is matched as read,
+	// before any other processing.
+	RotateOn   *regexp.Regexp
+	RotateOnAt SentinelPosition
+
+	// LineNumbers, if positive, prefixes every line read from the input
+	// with its number within the segment, counting from 1 at each rotation,
+	// zero-padded to LineNumbers digits and followed by ": ", so that lines
+	// missing from a segment stand out. The number comes first, before any
+	// input prefix or tags, and counts towards the size of the logfile.
+	// Data passed to Write is not numbered.
+	LineNumbers int
+
+	// Redact holds patterns whose matches are replaced by RedactMask in
+	// every line read from the input, before it is tagged and written, to
+	// keep secrets out of the logfile; RedactPatterns holds some common
+	// ones. Data passed to Write is not redacted.
+	Redact []*regexp.Regexp
+
+	// RedactMask replaces matches of Redact. It defaults to "***".
+	RedactMask string
+
+	// JSONEnvelope wraps every line read from the input that doesn't
+	// already hold a JSON object in one, as {"ts":...,"host":...,
+	// "message":...} followed by the Tags, so that the logfile can be
+	// ingested by log shippers without reframing. Lines holding a JSON
+	// object are passed through, with the Tags added as usual.
+	JSONEnvelope bool
+
+	// Hostname is the host name given in JSON envelopes. It defaults to
+	// the one reported by the operating system.
+	Hostname string
+
+	// WriteRetries is how many times a write to the logfile that failed
+	// with a transient error (EINTR, EAGAIN or EIO) is retried before the
+	// error is reported. The first retry waits WriteRetryDelay, which
+	// defaults to 10ms, and each further one waits twice as long as the
+	// last.
+	WriteRetries    int
+	WriteRetryDelay time.Duration
+
+	// WritePolicy selects how Write behaves while the logfile can't be
+	// written: WriteBlock, the default, or WriteDrop. It does not affect
+	// lines read by Run.
+	WritePolicy WritePolicy
+
+	// FailOnWriteError makes Run return the error when lines can't be
+	// written to the logfile, after any WriteRetries. By default, Run
+	// carries on and the lines are lost, counted in Stats.DroppedBytes.
+	// Either way, each failed write is sent to the Events channel as
+	// WriteFailed, and the first of a run of failures is reported to the
+	// ErrorLog.
+	FailOnWriteError bool
+
+	// LineTime, if set, additionally rotates the logfile by the timestamps
+	// found in the lines read from the input.
+	LineTime *LineTime
+
+	// Reorder, if set, holds lines read from the input briefly to write
+	// them in the order of their timestamps.
+	Reorder *Reorder
+
+	// RotateOnExit rotates the logfile, if not empty, once Run has read
+	// all of its input, so that the last segment is archived along with
+	// the rest rather than left for the next run to continue. Close waits
+	// for its compression to finish.
+	RotateOnExit bool
+
+	// Ring, if positive, keeps the last Ring segments uncompressed in a
+	// fixed set of files numbered 1 to Ring, reusing the slot of the oldest
+	// segment on each rotation, like a circular buffer on disk. Recent logs
+	// then take bounded space and are always readable, at no CPU cost.
+	// Compression, coalescing and retention do not apply.
+	Ring int
+
+	// KeepPrev keeps the most recently rotated segment uncompressed at a
+	// stable path, named like the logfile with ".prev" appended, so that
+	// the current and previous segments can always be read directly. On
+	// each rotation the segment previously kept there is numbered and
+	// compressed as usual, and replaced by the logfile.
+	KeepPrev bool
+
+	// CopyTruncate rotates by copying the logfile to the rotated segment
+	// and truncating it in place, rather than renaming it and creating a
+	// new one, for when another writer holds the logfile open by path.
+	// Lines that writer adds between the copy and the truncation are lost.
+	// It cannot be combined with KeepPrev or Ring.
+	CopyTruncate bool
+
+	// Sink, if set, receives a copy of each archive once it has been made,
+	// before the RotationCompleted event is sent. Archives that fail to
+	// upload are reported to the ErrorLog and kept, and the upload is
+	// retried like a failed compression. It cannot be combined with
+	// CoalesceBelow or DailyTar, whose archives keep growing.
+	Sink ArchiveSink
+
+	// RemoveUploaded deletes each archive once Sink has stored it, so that
+	// only the current segment and those not yet uploaded stay on disk.
+	RemoveUploaded bool
+
+	// OnArchive, if set, is called with the paths of each rotated segment
+	// and of its archive once the archive has been made and, if there is a
+	// Sink, uploaded, before the RotationCompleted event is sent. The two are
+	// the same if the segment was left uncompressed, and the archive is
+	// gone if RemoveUploaded deleted it. Unlike events, calls are never
+	// dropped: they are made one at a time, in the order of rotation, by
+	// the goroutine that compresses segments, with a context bounded by
+	// CompressTimeout. A call that fails is retried like a failed
+	// compression.
+	OnArchive func(ctx context.Context, segment, archive string) error
+
+	// DailyTar collects the segments rotated each day into a single
+	// tarball named like the logfile with "-YYYYMMDD.tar.gz" appended (or
+	// the extension of the Compressor, which must be appendable). Each
+	// segment is appended to the day's tarball as an entry of its own,
+	// named after the time of the rotation. Until the first rotation of a
+	// later day, or a restart on a later day, completes it, the tarball has
+	// ".part" appended to its name. Coalescing, retention and the manifest
+	// do not apply to tarballs.
+	DailyTar bool
+
+	// AdoptReversed prepares a directory previously managed by logrotate(8)
+	// for use: if the existing archives are numbered in reverse, with 1 the
+	// newest, they are renumbered on startup so that higher numbers are
+	// newer. Otherwise new archives would be numbered after the oldest ones,
+	// and retention would delete the wrong archives first. The renumbering
+	// is recorded in a journal named like the logfile with ".renumber"
+	// appended, so that one interrupted by a crash is finished on the next
+	// start.
+	AdoptReversed bool
+
+	// ContentAddressed names each archive after the SHA-256 hash of the
+	// segment it holds, as in app.log.sha256-<16 hex digits>.gz, instead
+	// of its sequence number, so that identical segments share an archive.
+	// Sequence numbers and rotation times are recorded in an index named
+	// like the logfile with ".cas" appended, which numbering and retention
+	// go by. Before an archive is shared, its content is checked, and an
+	// archive whose name is taken by different content keeps its numbered
+	// name. It cannot be combined with CoalesceBelow, DailyTar or Ring.
+	ContentAddressed bool
+
+	// FS is the file system holding the logfile and its archives. It
+	// defaults to the operating system's. Free space checks and the PID
+	// file always use the operating system's file system.
+	FS FS
+
+	// ErrorLog is where problems that don't stop the Rotator are reported.
+	// If nil, the log package's standard logger is used.
+	ErrorLog *log.Logger
+}
+
+// An Input is a source of log lines.
+type Input struct {
+	Reader io.Reader
+
+	// Prefix is prepended to every line read from Reader, to tell the
+	// sources apart in the merged logfile. It is added as the line is
+	// written, after any line number and before any tags, so RotateOn,
+	// LineTime, Reorder and JSON detection see the line as read.
+	Prefix string
+}
+
+// input is a source of lines being scanned.
+type input struct {
+	*bufio.Scanner
+	prefix string
+	cont   *bool // whether the last token is continued by the next
+	rest   *remainder
+}
+
+// A record is a line read from an input, kept apart from the input's
+// prefix until it is written, so that the line is parsed and matched as it
+// was read.
+type record struct {
+	prefix string
+	line   []byte
+}
+
+// A remainder holds what an input has read of the line it is in the middle
+// of, so that the line can be written at shutdown rather than lost.
+type remainder struct {
+	mu   sync.Mutex
+	long []byte // the pieces of a line too long to scan whole
+	tail []byte // input the Scanner holds that isn't part of a token yet
+}
+
+// newInput returns an input scanning rd into lines with split. A line too
+// long for the Scanner's buffer is scanned in pieces, with cont set for all
+// but the last, so that it can be joined back together rather than ending
+// the input with bufio.ErrTooLong.
+func newInput(rd io.Reader, prefix string, split bufio.SplitFunc) input {
+	in := input{Scanner: bufio.NewScanner(rd), prefix: prefix, cont: new(bool), rest: new(remainder)}
+	in.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := split(data, atEOF)
+		*in.cont = false
+		if advance == 0 && token == nil && err == nil && !atEOF && len(data) >= bufio.MaxScanTokenSize {
+			// Hold back the last byte, which may begin a delimiter.
+			*in.cont = true
+			advance, token = len(data)-1, data[:len(data)-1]
+		}
+		in.rest.mu.Lock()
+		in.rest.tail = in.rest.tail[:0]
+		if token == nil && advance < len(data) {
+			in.rest.tail = append(in.rest.tail, data[advance:]...)
+		}
+		in.rest.mu.Unlock()
+		return advance, token, err
+	})
+	return in
+}
+
+// partial returns the line in is in the middle of, without its prefix, or
+// nil if there is none.
+func (in input) partial() []byte {
+	in.rest.mu.Lock()
+	defer in.rest.mu.Unlock()
+	if len(in.rest.long) == 0 && len(in.rest.tail) == 0 {
+		return nil
+	}
+	line := append([]byte(nil), in.rest.long...)
+	return append(line, in.rest.tail...)
 }
 
 // New returns a new Rotator that is ready to start rotating logs from its
 // input.
 func New(in io.Reader, filename string, thresholdKB int64, tee bool) (*Rotator, error) {
-	f, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	return NewWithConfig(in, Config{
+		Filename:    filename,
+		ThresholdKB: thresholdKB,
+		Tee:         tee,
+	})
+}
+
+// NewWithConfig is like New, but takes its settings from a Config. The input
+// may be nil if cfg.Inputs are given instead. It fails if files can't be
+// created in the logfile's directory, or in cfg.TempDir, since rotation
+// would fail later on.
+func NewWithConfig(in io.Reader, cfg Config) (*Rotator, error) {
+	return newRotator(in, nil, cfg)
+}
+
+// NewWithFile is like NewWithConfig, but adopts f as the logfile instead of
+// opening cfg.Filename, for instance when the descriptor was passed in by a
+// supervisor. f must be the file at cfg.Filename, with cfg.CurrentSuffix
+// appended if set, which is still used for the files that replace it after
+// each rotation. It must be open for reading and writing; writes go to its
+// current offset, so it should normally have been opened with os.O_APPEND.
+// Its permissions are left as they are. The Rotator takes ownership of f: it
+// is closed by Close, or right away if NewWithFile fails.
+func NewWithFile(in io.Reader, f *os.File, cfg Config) (*Rotator, error) {
+	if f == nil {
+		return nil, errors.New("nil logfile")
+	}
+	return newRotator(in, f, cfg)
+}
+
+// newRotator constructs a Rotator writing to file, or to cfg.Filename if
+// file is nil.
+func newRotator(in io.Reader, file File, cfg Config) (r *Rotator, err error) {
+	// The logfile, passed in or opened below, is closed if construction
+	// fails, so that callers never need to.
+	f := file
+	defer func() {
+		if err != nil && f != nil {
+			f.Close()
+		}
+	}()
+
+	if cfg.ThresholdKB > 0 && cfg.ThresholdPercent > 0 {
+		return nil, errors.New("rotation threshold given both in kilobytes and as a percentage")
+	}
+	if cfg.TargetArchiveSize > 0 && cfg.ThresholdPercent > 0 {
+		return nil, errors.New("a target archive size cannot be combined with a percentage threshold")
+	}
+	if cfg.ThresholdPercent > 100 {
+		return nil, errors.New("rotation threshold percentage must not exceed 100")
+	}
+	if cfg.ThresholdKB <= 0 && cfg.ThresholdPercent <= 0 {
+		return nil, errors.New("rotation threshold must be positive")
+	}
+	if len(cfg.Delimiter) > 1 {
+		return nil, errors.New("delimiter must be a single byte")
+	}
+
+	if cfg.PIDFile != "" {
+		if err := writePIDFile(cfg.PIDFile); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				os.Remove(cfg.PIDFile)
+			}
+		}()
+	}
+
+	fsys := cfg.FS
+	if fsys == nil {
+		fsys = osFS{}
+	}
+	if cfg.Owner != "" || cfg.Group != "" {
+		uid, gid, err := lookupOwner(cfg.Owner, cfg.Group)
+		if err != nil {
+			return nil, err
+		}
+		fsys = withOwner(fsys, uid, gid)
+	}
+
+	naming, err := newNamer(fsys, cfg.Filename, cfg.Naming, cfg.NamingSep, cfg.NamingTimeLayout)
 	if err != nil {
 		return nil, err
 	}
+	if cfg.ContentAddressed {
+		naming.index = casIndexName(cfg.Filename)
+	}
+	if err := probeDir(fsys, filepath.Dir(cfg.Filename)); err != nil {
+		return nil, err
+	}
+	if cfg.TempDir != "" {
+		if err := probeDir(fsys, cfg.TempDir); err != nil {
+			return nil, err
+		}
+	}
+
+	mode := cfg.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+
+	live := cfg.Filename + cfg.CurrentSuffix
+	if cfg.CurrentSuffix != "" {
+		if err := linkCurrent(fsys, cfg.Filename, live); err != nil {
+			return nil, err
+		}
+	}
+
+	if f == nil {
+		f, err = openFile(fsys, live, os.O_CREATE|os.O_APPEND|os.O_RDWR, mode)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	stat, err := f.Stat()
 	if err != nil {
 		return nil, err
 	}
 
-	return &Rotator{
-		size:      stat.Size(),
-		threshold: 1000 * thresholdKB,
-		filename:  filename,
-		in:        bufio.NewScanner(in),
-		out:       f,
-		tee:       tee,
-	}, nil
+	comp := cfg.Compressor
+	if comp == nil {
+		comp = Gzip{}
+	}
+	ext := strings.TrimPrefix(cfg.CompressExt, ".")
+	if ext == "" {
+		ext = comp.Ext()
+	}
+	if strings.ContainsAny(ext, `/\`) {
+		return nil, errors.New("archive extension must not contain a path separator")
+	}
+	naming.exts = archiveExts(ext)
+	if naming.timed() && (cfg.Ring > 0 || cfg.CoalesceBelow > 0 || cfg.AdoptReversed || cfg.ContentAddressed) {
+		return nil, errors.New("names with the rotation time cannot be combined with a ring, coalescing, adopting reversed archives or content addressing")
+	}
+	if cfg.Sink != nil && (cfg.CoalesceBelow > 0 || cfg.DailyTar) {
+		return nil, errors.New("archives that are appended to cannot be uploaded")
+	}
+	if cfg.CopyTruncate && (cfg.KeepPrev || cfg.Ring > 0) {
+		return nil, errors.New("copytruncate cannot be combined with keeping the previous segment or a ring")
+	}
+	if cfg.Ring > 0 && cfg.CoalesceBelow > 0 {
+		return nil, errors.New("a ring of segments cannot be coalesced")
+	}
+	if cfg.DailyTar && cfg.CoalesceBelow > 0 {
+		return nil, errors.New("daily tarballs cannot be coalesced")
+	}
+	if cfg.NoCompression && (cfg.CoalesceBelow > 0 || cfg.DailyTar) {
+		return nil, errors.New("coalescing and daily tarballs require compression")
+	}
+	if cfg.DailyTar && !canAppend(comp) {
+		return nil, errors.New("daily tarballs require a compressor that can append")
+	}
+	if cfg.SegmentMeta && (cfg.KeepPrev || cfg.DailyTar) {
+		return nil, errors.New("segment metadata cannot be combined with a kept previous segment or daily tarballs")
+	}
+	if cfg.ContentAddressed && (cfg.CoalesceBelow > 0 || cfg.DailyTar || cfg.Ring > 0) {
+		return nil, errors.New("content-addressed archives cannot be coalesced, collected or kept in a ring")
+	}
+	if cfg.CoalesceBelow > 0 && !canAppend(comp) {
+		return nil, errors.New("coalescing archives requires a compressor that can append")
+	}
+
+	host := cfg.Hostname
+	if cfg.JSONEnvelope && host == "" {
+		host, _ = os.Hostname()
+	}
+
+	var headerName string
+	if cfg.ArchiveHeader {
+		headerName = filepath.Base(cfg.Filename)
+	}
+
+	var zSlots chan struct{}
+	if cfg.MaxPendingCompressions > 0 {
+		zSlots = make(chan struct{}, cfg.MaxPendingCompressions)
+	}
+
+	r = &Rotator{
+		size:       stat.Size(),
+		threshold:  1000 * cfg.ThresholdKB,
+		oversize:   cfg.Oversize,
+		maxLine:    cfg.MaxLineSize,
+		sizePct:    cfg.ThresholdPercent,
+		filename:   cfg.Filename,
+		live:       live,
+		out:        f,
+		fs:         fsys,
+		delim:      '\n',
+		tee:        cfg.Tee || cfg.TeePrimary,
+		teePrimary: cfg.TeePrimary,
+		pidfile:    cfg.PIDFile,
+		minFree:    cfg.MinFreeSpace,
+		naming:     naming,
+		comp:       comp,
+		ext:        ext,
+		idle:       cfg.IdleTimeout,
+		segAge:     cfg.MaxSegmentAge,
+		every:      cfg.RotateEvery,
+		inTimeout:  cfg.InputTimeout,
+		opened:     time.Now(),
+		jitter:     cfg.Jitter,
+		jitterEach: cfg.JitterEach,
+		minSize:    cfg.MinSize,
+		maxRotPM:   cfg.MaxRotationsPerMin,
+		failOnMax:  cfg.FailOnRotationLimit,
+		errorLog:   cfg.ErrorLog,
+		manifest:   cfg.Manifest,
+		coalesce:   cfg.CoalesceBelow,
+		tempDir:    cfg.TempDir,
+		zTimeout:   cfg.CompressTimeout,
+		zMinSize:   cfg.CompressMinSize,
+		zNone:      cfg.NoCompression,
+		verify:     cfg.VerifyArchives,
+		addressed:  cfg.ContentAddressed,
+		marker:     cfg.ShutdownMarker,
+		trailer:    cfg.SegmentTrailer,
+		meta:       cfg.SegmentMeta,
+		collide:    cfg.OnCollision,
+		numWidth:   cfg.LineNumbers,
+		zTarget:    cfg.TargetArchiveSize,
+		dictFile:   cfg.ZstdDict,
+		dictTrain:  cfg.ZstdTrain,
+		zSlots:     zSlots,
+		sentinel:   cfg.RotateOn,
+		sentAt:     cfg.RotateOnAt,
+		keepPlain:  cfg.KeepUncompressed,
+		tags:       newTagger(cfg.Tags, cfg.JSONEnvelope, host),
+		redact:     newRedactor(cfg.Redact, cfg.RedactMask),
+		retries:    cfg.WriteRetries,
+		retryDelay: cfg.WriteRetryDelay,
+		policy:     cfg.WritePolicy,
+		failWrite:  cfg.FailOnWriteError,
+		copyTrunc:  cfg.CopyTruncate,
+		sink:       cfg.Sink,
+		rmUploaded: cfg.RemoveUploaded,
+		onArchive:  cfg.OnArchive,
+		lineTime:   newLineTimer(cfg.LineTime),
+		reorder:    newReorderer(cfg.Reorder),
+		sealOnExit: cfg.RotateOnExit,
+		ring:       cfg.Ring,
+		dailyTar:   cfg.DailyTar,
+		keepPrev:   cfg.KeepPrev,
+		headerName: headerName,
+		hold:       cfg.FlushOnRotate || cfg.FlushInterval > 0,
+		holdMax:    maxHeld,
+		durable:    cfg.FlushOnRotate,
+		flushInt:   cfg.FlushInterval,
+		flushReq:   make(chan struct{}, 1),
+		syncPol:    cfg.Sync,
+		mode:       mode,
+		owner:      cfg.Owner,
+		group:      cfg.Group,
+		events:     make(chan Event, eventBuffer),
+		reopen:     make(chan struct{}, 1),
+		rotateReq:  make(chan struct{}, 1),
+		inflight:   make(map[int]bool),
+		pruneGrace: cfg.PruneGrace,
+		retention: retention{
+			keep:      cfg.Keep,
+			keepDaily: cfg.KeepDaily,
+			maxAge:    cfg.MaxAge,
+			maxTotal:  cfg.MaxTotalSize,
+		},
+	}
+
+	if r.sizePct > 0 {
+		if r.threshold, err = percentThreshold(r.filename, r.sizePct); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.retryDelay <= 0 {
+		r.retryDelay = 10 * time.Millisecond
+	}
+	if r.maxLine <= 0 {
+		r.maxLine = DefaultMaxLineSize
+	}
+	if r.flushInt > 0 {
+		r.holdMax = cfg.FlushSize
+		if r.holdMax <= 0 {
+			r.holdMax = DefaultFlushSize
+		}
+	}
+
+	split := cfg.Split
+	if cfg.Delimiter != "" {
+		r.delim = cfg.Delimiter[0]
+		if split == nil && r.delim != '\n' {
+			split = splitOn(r.delim)
+		}
+	}
+	if split == nil {
+		split = bufio.ScanLines
+	}
+	if in != nil {
+		r.inputs = append(r.inputs, newInput(in, "", split))
+	}
+	for _, in := range cfg.Inputs {
+		r.inputs = append(r.inputs, newInput(in.Reader, in.Prefix, split))
+	}
+
+	if r.ring > 0 {
+		r.ringSlot = r.lastSlot()
+	}
+
+	if r.jitter > 0 {
+		r.jitterOff = time.Duration(rand.Int63n(int64(r.jitter)))
+	}
+	if r.every > 0 {
+		r.nextClock = time.Now().Add(r.jittered(time.Until(nextBoundary(time.Now(), r.every))))
+	}
+
+	if err := r.naming.resumeRenumber(renumberJournal(cfg.Filename), mode); err != nil {
+		return nil, err
+	}
+	if cfg.AdoptReversed {
+		if err := r.naming.renumberReversed(renumberJournal(cfg.Filename), mode); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.ZstdDict != "" {
+		if _, ok := comp.(Zstd); !ok {
+			return nil, errors.New("a zstd dictionary requires the Zstd compressor")
+		}
+		if err := r.loadDict(); err != nil {
+			return nil, err
+		}
+		if r.zDict == nil && r.dictTrain <= 0 {
+			return nil, errors.New("zstd dictionary " + cfg.ZstdDict + " not found")
+		}
+	} else if cfg.ZstdTrain > 0 {
+		return nil, errors.New("training a zstd dictionary requires a path to keep it at")
+	}
+
+	if r.dailyTar {
+		r.archiveMu.Lock()
+		if err := r.finalizeTars(time.Now()); err != nil {
+			r.logf("finalizing tarballs: %v", err)
+		}
+		r.archiveMu.Unlock()
+	}
+
+	if r.manifest {
+		if err := r.writeManifest(); err != nil {
+			return nil, err
+		}
+	}
+
+	if (cfg.RotateOnStart || cfg.NoAppend) && r.size > 0 {
+		if err := r.rotate("start"); err != nil {
+			return nil, err
+		}
+		f = r.out
+		// Go by the file rather than r.size, as rotate can decline to
+		// rotate without failing.
+		if cfg.NoAppend {
+			info, err := r.out.Stat()
+			if err == nil && info.Size() > 0 {
+				err = errors.New("could not archive the existing logfile")
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return r, nil
 }
 
 // Run begins reading lines from the input and rotating logs as necessary.
 func (r *Rotator) Run() error {
-	for r.in.Scan() {
-		if r.size >= r.threshold {
-			if err := r.rotate(); err != nil {
+	return r.RunContext(context.Background())
+}
+
+// RunContext is like Run, but stops once ctx is done and returns
+// ctx.Err(). Before stopping, it writes what the inputs have left until they
+// reach the end of their input, or for ShutdownGrace at most, after which
+// any line an input is in the middle of is written as it is, and it rotates
+// if RotateOnExit is set. Close should be called afterwards as usual, to
+// wait for pending compressions.
+func (r *Rotator) RunContext(ctx context.Context) error {
+	r.running.Store(true)
+	defer r.running.Store(false)
+
+	scanned := make(chan record, 64)
+	done := make(chan struct{})
+	defer close(done)
+	go r.scan(scanned, done)
+
+	lines := (<-chan record)(scanned)
+	if r.reorder != nil {
+		lines = r.reorder.run(r, scanned, done)
+	}
+
+	var checkDisk <-chan time.Time
+	if r.minFree > 0 || r.sizePct > 0 {
+		t := time.NewTicker(FreeSpaceInterval)
+		defer t.Stop()
+		checkDisk = t.C
+	}
+
+	var idle *time.Timer
+	var idleC <-chan time.Time
+	if r.idle > 0 {
+		idle = time.NewTimer(r.jittered(r.idle))
+		defer idle.Stop()
+		idleC = idle.C
+	}
+
+	// startC fires if no input at all arrives within the input timeout.
+	var startC <-chan time.Time
+	if r.inTimeout > 0 {
+		start := time.NewTimer(r.inTimeout)
+		defer start.Stop()
+		startC = start.C
+	}
+
+	var seal *time.Timer
+	var sealC <-chan time.Time
+	if r.segAge > 0 {
+		seal = time.NewTimer(r.segAge)
+		defer seal.Stop()
+		sealC = seal.C
+	}
+
+	var flushC <-chan time.Time
+	if r.flushInt > 0 {
+		t := time.NewTicker(r.flushInt)
+		defer t.Stop()
+		flushC = t.C
+	}
+
+	var syncC <-chan time.Time
+	if r.syncPol.Interval > 0 {
+		t := time.NewTicker(r.syncPol.Interval)
+		defer t.Stop()
+		syncC = t.C
+	}
+
+	var clock *time.Timer
+	var clockC <-chan time.Time
+	if r.every > 0 {
+		clock = time.NewTimer(r.jittered(time.Until(nextBoundary(time.Now(), r.every))))
+		defer clock.Stop()
+		clockC = clock.C
+	}
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return r.drain()
+			}
+			startC = nil
+			if err := r.reopenIfRequested(); err != nil {
+				return err
+			}
+			closed, err := r.writeLines(line, lines)
+			if err != nil {
+				return err
+			}
+			if closed {
+				return r.drain()
+			}
+			if idle != nil {
+				if !idle.Stop() {
+					select {
+					case <-idle.C:
+					default:
+					}
+				}
+				idle.Reset(r.jittered(r.idle))
+			}
+
+		case <-idleC:
+			if r.timeRotatable() {
+				if err := r.rotate("idle"); err != nil {
+					return err
+				}
+			}
+
+		case <-startC:
+			return ErrNoInput
+
+		case <-ctx.Done():
+			if err := r.shutdown(lines); err != nil {
+				return err
+			}
+			return ctx.Err()
+
+		case <-flushC:
+			if err := r.writeHeld(); err != nil && r.failWrite {
+				return err
+			}
+
+		case <-r.flushReq:
+			if err := r.writeHeld(); err != nil && r.failWrite {
+				return err
+			}
+
+		case <-syncC:
+			r.syncOut()
+
+		case <-r.rotateReq:
+			if r.size > 0 {
+				if err := r.rotate("manual"); err != nil {
+					return err
+				}
+			}
+
+		case <-sealC:
+			if r.size > 0 && time.Since(r.opened) >= r.segAge {
+				if err := r.rotate("age"); err != nil {
+					return err
+				}
+			}
+			next := r.segAge - time.Since(r.opened)
+			if next <= 0 {
+				next = r.segAge
+			}
+			seal.Reset(next)
+
+		case <-clockC:
+			if r.timeRotatable() {
+				if err := r.rotate("schedule"); err != nil {
+					return err
+				}
+			}
+			clock.Reset(r.jittered(time.Until(nextBoundary(time.Now(), r.every))))
+
+		case <-checkDisk:
+			if r.sizePct > 0 {
+				if t, err := percentThreshold(r.filename, r.sizePct); err == nil {
+					r.threshold = t
+				}
+			}
+			if r.minFree <= 0 {
+				continue
+			}
+			free, err := freeSpace(r.filename)
+			if err != nil {
+				r.minFree = 0
+				continue
+			}
+			if free < r.minFree && r.size > 0 {
+				if err := r.rotate("free-space"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// writeWaiting writes the lines already waiting on lines.
+func (r *Rotator) writeWaiting(lines <-chan record) error {
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return nil
+			}
+			closed, err := r.writeLines(line, lines)
+			if err != nil || closed {
 				return err
 			}
+		default:
+			return nil
 		}
+	}
+}
+
+// shutdown finishes up once RunContext's context is done, writing the lines
+// still to come on lines until they run out or ShutdownGrace has passed,
+// and then the lines the inputs are in the middle of.
+func (r *Rotator) shutdown(lines <-chan record) error {
+	grace := time.NewTimer(ShutdownGrace)
+	defer grace.Stop()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return r.drain()
+			}
+			closed, err := r.writeLines(line, lines)
+			if err != nil {
+				return err
+			}
+			if closed {
+				return r.drain()
+			}
+
+		case <-grace.C:
+			if r.reorder != nil {
+				// Write the lines held for reordering ahead of the lines
+				// the inputs are in the middle of.
+				r.reorder.flush()
+				for line := range lines {
+					if _, err := r.writeLines(line, lines); err != nil {
+						return err
+					}
+				}
+			} else if err := r.writeWaiting(lines); err != nil {
+				return err
+			}
+			for _, in := range r.inputs {
+				if line := in.partial(); line != nil {
+					if _, err := r.writeLines(record{in.prefix, line}, nil); err != nil {
+						return err
+					}
+				}
+			}
+			return r.drain()
+		}
+	}
+}
 
-		line := r.in.Bytes()
+// drain finishes up once the input is exhausted.
+func (r *Rotator) drain() error {
+	if r.sealOnExit && r.size > 0 {
+		return r.rotate("exit")
+	}
+	return nil
+}
+
+// nextBoundary returns the first time after now that is a multiple of every
+// since the local midnight, or the next midnight if that comes first. An
+// interval of more than a day is taken in whole days.
+func nextBoundary(now time.Time, every time.Duration) time.Time {
+	y, m, d := now.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	tomorrow := midnight.AddDate(0, 0, 1)
+	if every >= 24*time.Hour {
+		return midnight.AddDate(0, 0, int(every/(24*time.Hour)))
+	}
+	next := midnight.Add((now.Sub(midnight)/every + 1) * every)
+	if next.After(tomorrow) {
+		return tomorrow
+	}
+	return next
+}
 
-		n, _ := r.out.Write(line)
-		m, _ := r.out.Write([]byte{'\n'})
+// jittered returns d delayed by the configured jitter.
+func (r *Rotator) jittered(d time.Duration) time.Duration {
+	if r.jitter <= 0 {
+		return d
+	}
+	if r.jitterEach {
+		return d + time.Duration(rand.Int63n(int64(r.jitter)))
+	}
+	return d + r.jitterOff
+}
 
-		if r.tee {
-			os.Stdout.Write(line)
-			os.Stdout.Write([]byte{'\n'})
+// appendLine appends the line of rec to buf in the form it is written to
+// the logfile, after its input's prefix and terminated by the delimiter.
+func (r *Rotator) appendLine(buf []byte, rec record) []byte {
+	line := rec.line
+	r.nLines.Add(1)
+	r.lineNo++
+	if r.meta {
+		r.touch()
+	}
+	if r.numWidth > 0 {
+		buf = r.appendLineNumber(buf)
+	}
+	buf = append(buf, rec.prefix...)
+	if r.redact != nil {
+		line = r.redact.redact(line)
+	}
+	if r.tags != nil {
+		buf = r.tags.appendTagged(buf, line)
+	} else {
+		buf = append(buf, line...)
+	}
+	if len(line) == 0 || line[len(line)-1] != r.delim {
+		buf = append(buf, r.delim)
+	}
+	return buf
+}
+
+// ScanLinesCR is a bufio.SplitFunc like bufio.ScanLines, but also ends lines
+// at a lone carriage return, as written by classic Mac OS and some embedded
+// systems. Use it as Config.Split for such input.
+func ScanLinesCR(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		switch {
+		case data[i] == '\n':
+			return i + 1, data[:i], nil
+		case i+1 < len(data) && data[i+1] == '\n':
+			return i + 2, data[:i], nil
+		case i+1 < len(data) || atEOF:
+			return i + 1, data[:i], nil
 		}
+		// Wait to see whether a newline follows.
+		return 0, nil, nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
 
-		r.size += int64(n + m)
+// splitOn returns a bufio.SplitFunc that splits its input into records
+// terminated by delim. A final record without a delimiter is returned too.
+func splitOn(delim byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if atEOF && len(data) == 0 {
+			return 0, nil, nil
+		}
+		if i := bytes.IndexByte(data, delim); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
 	}
+}
+
+// appendLineNumber appends the number of the next line in the segment to
+// buf, padded to the configured width.
+func (r *Rotator) appendLineNumber(buf []byte) []byte {
+	n := strconv.Itoa(r.lineNo)
+	for i := len(n); i < r.numWidth; i++ {
+		buf = append(buf, '0')
+	}
+	buf = append(buf, n...)
+	return append(buf, ": "...)
+}
 
+// timeRotatable reports whether the logfile is large enough for a
+// time-triggered rotation.
+func (r *Rotator) timeRotatable() bool {
+	return r.size > 0 && r.size >= r.minSize
+}
+
+// scan reads lines from all inputs and sends them on lines until the inputs
+// are exhausted or done is closed.
+func (r *Rotator) scan(lines chan<- record, done <-chan struct{}) {
+	var wg sync.WaitGroup
+	for _, in := range r.inputs {
+		wg.Add(1)
+		go func(in input) {
+			defer wg.Done()
+			send := func(line []byte) bool {
+				select {
+				case lines <- record{in.prefix, line}:
+					return true
+				case <-done:
+					return false
+				}
+			}
+			for in.Scan() {
+				in.rest.mu.Lock()
+				if *in.cont {
+					in.rest.long = r.appendCapped(in.rest.long, in.Bytes())
+					in.rest.mu.Unlock()
+					continue
+				}
+				line := r.appendCapped(in.rest.long, in.Bytes())
+				in.rest.long = nil
+				in.rest.mu.Unlock()
+				if !send(line) {
+					return
+				}
+			}
+			if line := in.partial(); line != nil && !send(line) {
+				return
+			}
+			if err := in.Err(); err != nil {
+				r.logf("reading input: %v", err)
+			}
+		}(in)
+	}
+	wg.Wait()
+	close(lines)
+}
+
+// appendCapped appends as much of piece to line as MaxLineSize allows, and
+// drops the rest.
+func (r *Rotator) appendCapped(line, piece []byte) []byte {
+	if room := r.maxLine - len(line); len(piece) > room {
+		if room < 0 {
+			room = 0
+		}
+		r.drop(piece[room:])
+		piece = piece[:room]
+	}
+	return append(line, piece...)
+}
+
+// writeLines writes line to the logfile, rotating first if the threshold
+// has been reached. Any further lines already waiting on lines are written in
+// the same call, up to the point where the threshold would be reached, so
+// that bursts of input cost a single write. It reports whether lines turned
+// out to be closed.
+func (r *Rotator) writeLines(rec record, lines <-chan record) (closed bool, err error) {
+	buf := r.buf[:0]
+	pending := false // rotate before the next line
+
+batch:
+	for {
+		sentinel := r.sentinel != nil && r.sentinel.Match(rec.line)
+		if sentinel && r.sentAt != SentinelAfter && r.size+int64(len(buf)) > 0 {
+			pending = true
+		}
+		// The window is checked for every line, so that it moves on even
+		// when the logfile is rotated for another reason.
+		window := r.lineTime.startsWindow(r, rec.line)
+		var reason string
+		switch {
+		case pending:
+			reason = "match"
+		case r.size+int64(len(buf)) >= r.threshold:
+			reason = "size"
+		case window:
+			reason = "line-time"
+		}
+		if reason != "" {
+			if err := r.flush(buf); err != nil {
+				r.buf = buf
+				return false, err
+			}
+			buf = buf[:0]
+			if err := r.rotate(reason); err != nil {
+				r.buf = buf
+				return false, err
+			}
+			pending = false
+		}
+
+		start := len(buf)
+		if !sentinel || r.sentAt != SentinelDrop {
+			buf = r.appendLine(buf, rec)
+		}
+		pending = sentinel && r.sentAt == SentinelAfter
+		if r.oversize != OversizeOverflow && int64(len(buf)-start) > r.threshold {
+			r.over = append(r.over[:0], buf[start:]...)
+			err := r.flush(buf[:start])
+			buf = buf[:0]
+			if err == nil {
+				err = r.writeOversized(r.over)
+			}
+			if err != nil {
+				r.buf = buf
+				return false, err
+			}
+		}
+		if len(buf) >= maxBatch || r.size+int64(len(buf)) >= r.threshold {
+			break batch
+		}
+
+		select {
+		case next, ok := <-lines:
+			if !ok {
+				closed = true
+				break batch
+			}
+			rec = next
+		default:
+			break batch
+		}
+	}
+
+	err = r.flush(buf)
+	r.buf = buf
+	if err == nil && pending {
+		err = r.rotate("match")
+	}
+	return closed, err
+}
+
+// flush writes buf to the logfile and, if teeing, to standard output. It
+// only fails if standard output is the primary destination and can't be
+// written, or if the logfile can't be and FailOnWriteError is set; data that
+// can't be written is otherwise dropped.
+func (r *Rotator) flush(buf []byte) error {
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if r.tee {
+		if _, err := os.Stdout.Write(buf); err != nil && r.teePrimary {
+			return err
+		}
+	}
+
+	if r.hold {
+		r.held = append(r.held, buf...)
+		r.size += int64(len(buf))
+		if len(r.held) >= r.holdMax {
+			if err := r.writeHeld(); err != nil && r.failWrite {
+				return err
+			}
+		}
+		return nil
+	}
+
+	n, err := r.writeOut(buf)
+	r.setWrite(err)
+	r.size += int64(n)
+	if err != nil {
+		if r.failWrite {
+			return err
+		}
+		r.drop(buf[n:])
+	}
 	return nil
 }
 
-// Close closes the output logfile.
+// writeHeld writes the output held back by FlushOnRotate or FlushInterval
+// to the logfile. What can't be written is dropped.
+func (r *Rotator) writeHeld() error {
+	if len(r.held) == 0 {
+		return nil
+	}
+	n, err := r.writeOut(r.held)
+	r.setWrite(err)
+	r.size -= int64(len(r.held) - n)
+	if err != nil {
+		r.drop(r.held[n:])
+	}
+	r.held = r.held[:0]
+	return err
+}
+
+// Close writes the shutdown marker, if any, closes the output logfile, waits
+// for pending compressions, removes the PID file, if any, and closes the
+// channel returned by Events. With a CompressTimeout, it stops waiting once
+// no compression has finished for that long, and returns an error naming
+// the segments left uncompressed. Calling Close again, or Write after Close,
+// returns os.ErrClosed.
 func (r *Rotator) Close() error {
+	r.writeMu.Lock()
+	if r.closed {
+		r.writeMu.Unlock()
+		return os.ErrClosed
+	}
+	r.closed = true
+	if r.flushT != nil {
+		r.flushT.Stop()
+	}
+	if r.syncT != nil {
+		r.syncT.Stop()
+	}
+	r.writeMarker()
+	r.writeHeld()
+	r.syncOut()
 	err := r.out.Close()
-	r.wg.Wait()
+	r.writeMu.Unlock()
+	if werr := r.waitCompressions(); err == nil {
+		err = werr
+	}
+	if r.pidfile != "" {
+		os.Remove(r.pidfile)
+	}
+	r.eventsMu.Lock()
+	r.evClosed = true
+	close(r.events)
+	r.eventsMu.Unlock()
 	return err
 }
 
-func (r *Rotator) rotate() error {
-	dir := filepath.Dir(r.filename)
-	glob := filepath.Join(dir, filepath.Base(r.filename)+".*")
-	existing, err := filepath.Glob(glob)
-	if err != nil {
-		return err
+// waitCompressions waits for pending compressions to finish. With a
+// CompressTimeout it gives up once none has finished for that long, plus a
+// second for the partial archive to be removed, since the timeout is only
+// noticed between reads and a read stuck on a stalled disk may never return.
+func (r *Rotator) waitCompressions() error {
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	if r.zTimeout <= 0 {
+		<-done
+		return nil
 	}
 
-	maxNum := 0
-	for _, name := range existing {
-		parts := strings.Split(name, ".")
-		if len(parts) < 2 {
-			continue
-		}
-		numIdx := len(parts) - 1
-		if parts[numIdx] == "gz" {
-			numIdx--
+	pending := r.pendingSeqs()
+	t := time.NewTimer(r.zTimeout + time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-t.C:
 		}
-		num, err := strconv.Atoi(parts[numIdx])
-		if err != nil {
+		if now := r.pendingSeqs(); len(now) < len(pending) {
+			pending = now
+			t.Reset(r.zTimeout + time.Second)
 			continue
 		}
-		if num > maxNum {
-			maxNum = num
+		return fmt.Errorf("gave up waiting to compress segments %v", pending)
+	}
+}
+
+// pendingSeqs returns the sequence numbers of the segments waiting to be
+// compressed or being compressed, in order.
+func (r *Rotator) pendingSeqs() []int {
+	r.inflightMu.Lock()
+	defer r.inflightMu.Unlock()
+	seqs := make([]int, 0, len(r.inflight))
+	for seq := range r.inflight {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	return seqs
+}
+
+// rotate seals the current segment and starts a new one. The reason is
+// recorded in the segment's metadata.
+func (r *Rotator) rotate(reason string) error {
+	if r.maxRotPM > 0 {
+		if !r.allowRotation(time.Now()) {
+			if r.failOnMax {
+				return ErrRotationLimit
+			}
+			if !r.limited {
+				r.logf("%d rotations within the last minute; pausing rotation", r.maxRotPM)
+				r.limited = true
+			}
+			return nil
+		}
+		if r.limited {
+			r.logf("rotation resumed")
+			r.limited = false
 		}
 	}
 
-	err = r.out.Close()
-	if err != nil {
+	if err := r.writeTrailer(); err != nil {
 		return err
 	}
-	rotname := fmt.Sprintf("%s.%d", r.filename, maxNum+1)
-	err = os.Rename(r.filename, rotname)
+	if r.hold {
+		if err := r.writeHeld(); err != nil {
+			return err
+		}
+	}
+	if r.durable {
+		if err := r.out.Sync(); err != nil {
+			return err
+		}
+		r.unsynced = 0
+	}
+	r.syncOut()
+
+	if r.ring > 0 {
+		return r.rotateRing(reason)
+	}
+
+	maxNum, err := r.naming.last()
 	if err != nil {
 		return err
 	}
-	r.out, err = os.OpenFile(r.filename, os.O_CREATE|os.O_RDWR, 0644)
+
+	// The old segment is compressed through the handle we already hold, so
+	// nothing can swap the file out from under us between the rename and
+	// the compression.
+	old := r.out
+	seq, appending := maxNum+1, false
+	if r.coalesce > 0 && maxNum > 0 && r.canCoalesce(maxNum) {
+		seq, appending = maxNum, true
+	}
+	rotname := r.naming.format(seq)
+	job := compressJob{
+		seq:       seq,
+		segment:   rotname,
+		appending: appending,
+		rotated:   time.Now(),
+	}
+	moved := rotname
+	switch {
+	case r.keepPrev:
+		moved = prevName(r.filename)
+		old, err = r.rotatePrev(job)
+	case r.copyTrunc:
+		old, err = r.copyTruncate(rotname)
+	default:
+		err = r.fs.Rename(r.live, rotname)
+	}
 	if err != nil {
 		return err
 	}
+	if !r.copyTrunc {
+		f, err := openFile(r.fs, r.live, os.O_CREATE|os.O_RDWR, r.mode)
+		if err != nil {
+			// Put the logfile back, so that writing carries on in it. A
+			// segment already moved out of KeepPrev's way is compressed
+			// later.
+			r.fs.Rename(moved, r.live)
+			if r.keepPrev && old != nil {
+				old.Close()
+				r.queueRetry(job)
+			}
+			return err
+		}
+		if r.keepPrev {
+			r.out.Close()
+		}
+		r.out = f
+	}
+	if r.meta {
+		if err := r.writeMeta(rotname, seq, appending, reason); err != nil {
+			r.logf("writing metadata of %s: %v", rotname, err)
+		}
+	}
 	r.size = 0
+	r.opened = time.Now()
+	r.nRotated.Add(1)
+	r.lineNo = 0
+	r.first, r.last = time.Time{}, time.Time{}
+	if t := r.nextThr.Load(); t > 0 {
+		r.threshold = t
+	}
+	r.syncDir()
+	r.emit(Event{Type: RotationStarted, Segment: moved})
+	if old == nil {
+		return nil
+	}
+
+	if r.zSlots != nil {
+		select {
+		case r.zSlots <- struct{}{}:
+			if r.zBehind {
+				r.logf("compression caught up")
+				r.zBehind = false
+			}
+		default:
+			if !r.zBehind {
+				r.logf("%d segments waiting for compression; pausing input while it catches up", cap(r.zSlots))
+				r.zBehind = true
+			}
+			r.zSlots <- struct{}{}
+		}
+	}
 
+	// Compressions run one at a time, in the order of rotation, so that
+	// rapid rotations can't race each other over the directory.
+	prev, done := r.zChain, make(chan struct{})
+	r.zChain = done
+	r.setInflight(seq, true)
 	r.wg.Add(1)
 	go func() {
-		err := compress(rotname)
-		if err == nil {
-			os.Remove(rotname)
+		if prev != nil {
+			<-prev
 		}
+		defer close(done)
+		if err := r.archive(old, job); err != nil {
+			r.queueRetry(job)
+		}
+		r.setInflight(seq, false)
+		if r.zSlots != nil {
+			<-r.zSlots
+		}
+		r.retryFailed()
+		r.tidyArchives()
 		r.wg.Done()
 	}()
 
 	return nil
 }
 
-func compress(name string) (err error) {
-	f, err := os.Open(name)
+// archive compresses the rotated segment read from src as described by job,
+// and closes src. The segment is removed once it has been compressed.
+// Segments smaller than CompressMinSize are left as they are.
+func (r *Rotator) archive(src File, job compressJob) error {
+	if (r.zNone || r.zMinSize > 0) && !job.appending && !r.dailyTar {
+		if info, err := src.Stat(); r.zNone || err == nil && info.Size() < r.zMinSize {
+			src.Close()
+			r.archived(job, job.segment, true)
+			return nil
+		}
+	}
+
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if r.zTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, r.zTimeout)
+	}
+	defer cancel()
+
+	var plain int64
+	if info, err := src.Stat(); err == nil {
+		plain = info.Size()
+	}
+
+	arcname := job.segment + "." + r.ext
+	if !job.appending && !r.dailyTar {
+		if _, err := r.fs.Stat(arcname); err == nil {
+			switch r.collide {
+			case CollisionOverwrite:
+				r.fs.Remove(arcname)
+				r.fs.Remove(arcname + ".gzi")
+			case CollisionSkip:
+				src.Close()
+				r.logf("%s already exists; leaving %s uncompressed", arcname, job.segment)
+				r.archived(job, job.segment, false)
+				return nil
+			}
+		}
+	}
+
+	if !r.dailyTar {
+		r.sampleForDict(src)
+	}
+	comp := r.archiveComp()
+
+	start := time.Now()
+	_, err := src.Seek(0, io.SeekStart)
+	if err == nil && r.dailyTar {
+		arcname, err = r.appendTar(ctx, src, job.rotated)
+	} else if err == nil {
+		opts := archiveOptions{
+			fs:      r.fs,
+			comp:    comp,
+			ext:     r.ext,
+			mode:    r.mode,
+			tempDir: r.tempDir,
+			name:    r.headerName,
+			modTime: job.rotated,
+		}
+		if r.addressed {
+			opts.hash = sha256.New()
+		}
+		err = compress(ctx, src, job.segment, job.appending, opts)
+		if err == nil && r.verify && !job.appending {
+			if err = verifyArchive(r.fs, arcname, src, comp); err != nil {
+				r.fs.Remove(arcname)
+				r.fs.Remove(arcname + ".gzi")
+			}
+		}
+		if err == nil && r.addressed {
+			arcname, err = r.address(arcname, job, opts.hash.Sum(nil), comp)
+		}
+	}
+	src.Close()
+	r.health.setCompress(err)
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			r.logf("compressing %s took longer than %v; leaving it uncompressed", job.segment, r.zTimeout)
+		}
+		r.emit(Event{Type: CompressionFailed, Segment: job.segment, Err: err})
 		return err
 	}
-	defer f.Close()
+	r.zNanos.Add(int64(time.Since(start)))
+	r.nZipped.Add(1)
 
-	arc, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if !job.appending && !r.dailyTar {
+		if info, err := r.fs.Stat(arcname); err == nil {
+			r.adaptThreshold(plain, info.Size())
+		}
+	}
+	if !r.keepPlain {
+		r.fs.Remove(job.segment)
+	}
+	r.syncDir()
+	r.archived(job, arcname, true)
+	return nil
+}
+
+// archived finishes with the segment of job once it has been archived as
+// arcname, which is the segment itself if it was left uncompressed: the
+// archive is uploaded, if upload is set, and passed to OnArchive once it
+// has been, and RotationCompleted is sent.
+func (r *Rotator) archived(job compressJob, arcname string, upload bool) {
+	if !upload || r.sink == nil || r.upload(job, arcname) {
+		r.hookArchive(job, arcname)
+	}
+	r.emit(Event{Type: RotationCompleted, Segment: job.segment, Archive: arcname})
+}
+
+// hookArchive calls OnArchive, if it is set, for the segment of job and its
+// archive arcname. A call that fails is retried later.
+func (r *Rotator) hookArchive(job compressJob, arcname string) {
+	if r.onArchive == nil {
+		return
+	}
+	if err := r.callOnArchive(job.segment, arcname); err != nil {
+		r.logf("OnArchive for %s: %v", arcname, err)
+		job.step, job.archive, job.attempts = stepOnArchive, arcname, 0
+		r.queueRetry(job)
+	}
+}
+
+// callOnArchive calls OnArchive for segment and its archive, within
+// CompressTimeout.
+func (r *Rotator) callOnArchive(segment, archive string) error {
+	ctx, cancel := r.stepContext()
+	defer cancel()
+	return r.onArchive(ctx, segment, archive)
+}
+
+// stepContext returns the context for an upload or a call of OnArchive,
+// which is bounded by CompressTimeout if it is set.
+func (r *Rotator) stepContext() (context.Context, context.CancelFunc) {
+	if r.zTimeout > 0 {
+		return context.WithTimeout(context.Background(), r.zTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// syncDir makes the renames done by rotation durable under FlushOnRotate.
+func (r *Rotator) syncDir() {
+	if !r.durable {
+		return
+	}
+	if err := syncDir(r.fs, filepath.Dir(r.filename)); err != nil {
+		r.logf("syncing directory: %v", err)
+	}
+}
+
+// prevName returns the path at which KeepPrev keeps the previous segment.
+func prevName(filename string) string {
+	return filename + ".prev"
+}
+
+// rotatePrev moves the logfile to its ".prev" path, after moving the segment
+// previously there to job.segment to be compressed. It returns a handle on
+// that segment, or nil if there was none. If the logfile can't be moved,
+// the segment is queued to be compressed later, as it has already been
+// numbered.
+func (r *Rotator) rotatePrev(job compressJob) (File, error) {
+	prev := prevName(r.filename)
+	var old File
+	err := r.fs.Rename(prev, job.segment)
+	if err == nil {
+		old, err = r.fs.OpenFile(job.segment, os.O_RDONLY, 0)
+		if err != nil {
+			r.queueRetry(job)
+		}
+	} else if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	z := gzip.NewWriter(arc)
-	if _, err = io.Copy(z, f); err != nil {
-		return err
+	if err := r.fs.Rename(r.live, prev); err != nil {
+		if old != nil {
+			old.Close()
+			r.queueRetry(job)
+		}
+		return nil, err
 	}
-	if err = z.Close(); err != nil {
-		return err
+	return old, nil
+}
+
+// copyTruncate copies the logfile to rotname and truncates it in place, for
+// CopyTruncate. It returns the copy.
+func (r *Rotator) copyTruncate(rotname string) (File, error) {
+	dst, err := openFile(r.fs, rotname, os.O_CREATE|os.O_EXCL|os.O_RDWR, r.mode)
+	if err != nil {
+		return nil, err
+	}
+	_, err = r.out.Seek(0, io.SeekStart)
+	if err == nil {
+		_, err = io.Copy(dst, r.out)
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if err == nil {
+		err = r.out.Truncate(0)
+	}
+	if err != nil {
+		dst.Close()
+		r.fs.Remove(rotname)
+		return nil, err
+	}
+	_, err = r.out.Seek(0, io.SeekStart)
+	return dst, err
+}
+
+// allowRotation reports whether another rotation at time now stays within
+// MaxRotationsPerMin, and if so records it.
+func (r *Rotator) allowRotation(now time.Time) bool {
+	i := 0
+	for i < len(r.recentRot) && now.Sub(r.recentRot[i]) >= time.Minute {
+		i++
+	}
+	r.recentRot = r.recentRot[i:]
+
+	if len(r.recentRot) >= r.maxRotPM {
+		return false
+	}
+	r.recentRot = append(r.recentRot, now)
+	return true
+}
+
+// percentThreshold returns pct percent of the capacity of the volume holding
+// path, in bytes.
+func percentThreshold(path string, pct float64) (int64, error) {
+	size, err := diskSize(path)
+	if err != nil {
+		return 0, err
+	}
+	if t := int64(float64(size) * pct / 100); t > 0 {
+		return t, nil
+	}
+	return 1, nil
+}
+
+// logf reports a problem to the ErrorLog.
+func (r *Rotator) logf(format string, args ...interface{}) {
+	if r.errorLog != nil {
+		r.errorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// canCoalesce reports whether the next segment can be appended to the
+// archive with sequence number seq.
+func (r *Rotator) canCoalesce(seq int) bool {
+	// An uncompressed segment means the archive is still being written.
+	if _, err := r.fs.Stat(r.naming.format(seq)); err == nil {
+		return false
+	}
+	info, err := r.fs.Stat(r.naming.format(seq) + "." + r.ext)
+	return err == nil && info.Size() < r.coalesce
+}
+
+// tidyArchives applies the retention policy and updates the manifest after
+// a segment has been compressed.
+func (r *Rotator) tidyArchives() {
+	r.archiveMu.Lock()
+	defer r.archiveMu.Unlock()
+
+	if r.retention.enabled() {
+		if err := r.prune(); err != nil {
+			r.logf("pruning archives: %v", err)
+		}
+	}
+	if r.manifest {
+		if err := r.writeManifest(); err != nil {
+			r.logf("writing manifest: %v", err)
+		}
 	}
-	return arc.Close()
 }
@@ -0,0 +1,388 @@
+package rotator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BenchmarkLines compares the writes to the logfile made by Run, which
+// batches the lines waiting to be written, with one Write call per line.
+func BenchmarkLines(b *testing.B) {
+	line := strings.Repeat("x", 79) + "\n"
+	newRotator := func(b *testing.B, writes *atomic.Int64) *Rotator {
+		count := func(op, name string) error {
+			if op == "write" {
+				writes.Add(1)
+			}
+			return nil
+		}
+		in := bytes.NewReader([]byte(strings.Repeat(line, b.N)))
+		fsys := withFaults(newMemFS("/logs"), count)
+		r, err := NewWithConfig(in, Config{Filename: "/logs/app.log", ThresholdKB: 1 << 30, FS: fsys})
+		if err != nil {
+			b.Fatal(err)
+		}
+		return r
+	}
+
+	b.Run("Run", func(b *testing.B) {
+		var writes atomic.Int64
+		r := newRotator(b, &writes)
+		b.SetBytes(int64(len(line)))
+		b.ResetTimer()
+		if err := r.Run(); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+		r.Close()
+		b.ReportMetric(float64(writes.Load())/float64(b.N), "writes/line")
+	})
+
+	b.Run("Write", func(b *testing.B) {
+		var writes atomic.Int64
+		r := newRotator(b, &writes)
+		b.SetBytes(int64(len(line)))
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := r.Write([]byte(line)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		r.Close()
+		b.ReportMetric(float64(writes.Load())/float64(b.N), "writes/line")
+	})
+}
+
+func TestNonPositiveThreshold(t *testing.T) {
+	for _, cfg := range []Config{
+		{ThresholdKB: 0},
+		{ThresholdKB: -1},
+		{ThresholdPercent: -5},
+		{ThresholdKB: -1, ThresholdPercent: -1},
+	} {
+		m := newMemFS("/logs")
+		cfg.Filename = "/logs/app.log"
+		cfg.FS = m
+		if r, err := NewWithConfig(nil, cfg); err == nil {
+			r.Close()
+			t.Errorf("ThresholdKB %d, ThresholdPercent %g: got no error", cfg.ThresholdKB, cfg.ThresholdPercent)
+		}
+		if names := m.names("/logs"); len(names) > 0 {
+			t.Errorf("ThresholdKB %d, ThresholdPercent %g: left files %v", cfg.ThresholdKB, cfg.ThresholdPercent, names)
+		}
+	}
+	if r, err := New(nil, filepath.Join(t.TempDir(), "app.log"), 0, false); err == nil {
+		r.Close()
+		t.Error("New with a zero threshold: got no error")
+	}
+}
+
+func TestRunFiniteInput(t *testing.T) {
+	m := newMemFS("/logs")
+	line := strings.Repeat("x", 99) + "\n"
+	input := strings.Repeat(line, 50)
+	r, err := NewWithConfig(strings.NewReader(input), Config{Filename: "/logs/app.log", ThresholdKB: 1, RotateOnExit: true, FS: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	// Every line is in an archive, and each one is compressed.
+	var got []byte
+	for i := 1; i < len(m.names("/logs")); i++ {
+		got = append(got, gunzip(t, m.read(t, fmt.Sprintf("/logs/app.log.%d.gz", i)))...)
+	}
+	if got := m.read(t, "/logs/app.log"); len(got) > 0 {
+		t.Errorf("logfile holds %d bytes after RotateOnExit, want 0", len(got))
+	}
+	if string(got) != input {
+		t.Errorf("archives hold %d bytes, want the %d read; files: %v", len(got), len(input), m.names("/logs"))
+	}
+}
+
+func TestCompressExt(t *testing.T) {
+	for _, ext := range []string{"gz.archive", ".7z"} {
+		m := newMemFS("/logs")
+		line := strings.Repeat("x", 99) + "\n"
+		// Restart halfway, so that the second Rotator has to find the
+		// archives the first one made by their extension.
+		for run := 0; run < 2; run++ {
+			r, err := NewWithConfig(nil, Config{Filename: "/logs/app.log", ThresholdKB: 1, CompressExt: ext, Keep: 3, FS: m})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 30; i++ {
+				if _, err := r.Write([]byte(line)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := r.Close(); err != nil {
+				t.Fatal(err)
+			}
+			for range r.Events() {
+			}
+		}
+
+		suffix := "." + strings.TrimPrefix(ext, ".")
+		want := []string{"app.log", "app.log.3" + suffix, "app.log.4" + suffix, "app.log.5" + suffix}
+		if got := m.names("/logs"); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("CompressExt %q: got files %v, want %v", ext, got, want)
+			continue
+		}
+		for _, name := range want[1:] {
+			if got := gunzip(t, m.read(t, "/logs/"+name)); !bytes.Equal(got, []byte(strings.Repeat(line, len(got)/len(line)))) || len(got) == 0 {
+				t.Errorf("CompressExt %q: %s holds %q", ext, name, got)
+			}
+		}
+	}
+}
+
+func TestNoAppend(t *testing.T) {
+	m := newMemFS("/logs")
+	m.write("/logs/app.log.1.gz", nil)
+	m.write("/logs/app.log", []byte("left over\n"))
+	r, err := NewWithConfig(nil, Config{Filename: "/logs/app.log", ThresholdKB: 1, NoAppend: true, FS: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("new\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	if got := string(m.read(t, "/logs/app.log")); got != "new\n" {
+		t.Errorf("logfile holds %q, want only what this run wrote", got)
+	}
+	if got := string(gunzip(t, m.read(t, "/logs/app.log.2.gz"))); got != "left over\n" {
+		t.Errorf("archive holds %q, want the left over content", got)
+	}
+}
+
+func TestNewWithFileClosesOnError(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "app.log")
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewWithFile(nil, f, Config{Filename: name}); err == nil {
+		t.Fatal("got no error without a threshold")
+	}
+	if err := f.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("logfile not closed by the failed NewWithFile: Close returned %v", err)
+	}
+}
+
+func TestRunContextShutdown(t *testing.T) {
+	defer func(d time.Duration) { ShutdownGrace = d }(ShutdownGrace)
+	ShutdownGrace = 100 * time.Millisecond
+
+	for _, tt := range []struct {
+		name  string
+		after string // written once the context is done, if the input is then closed
+		want  string
+	}{
+		{"EOF", "o\nthree\n", "one\ntwo\nthree\n"},
+		{"Grace", "", "one\ntw\n"},
+	} {
+		m := newMemFS("/logs")
+		pr, pw := io.Pipe()
+		r, err := NewWithConfig(pr, Config{Filename: "/logs/app.log", ThresholdKB: 1, FS: m})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- r.RunContext(ctx) }()
+
+		io.WriteString(pw, "one\ntw")
+		cancel()
+		if tt.after != "" {
+			io.WriteString(pw, tt.after)
+			pw.Close()
+		}
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: RunContext returned %v", tt.name, err)
+		}
+		pw.Close()
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		for range r.Events() {
+		}
+		if got := string(m.read(t, "/logs/app.log")); got != tt.want {
+			t.Errorf("%s: logfile holds %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMaxLineSize(t *testing.T) {
+	m := newMemFS("/logs")
+	// The long line is scanned in pieces, as it doesn't fit in the
+	// Scanner's buffer.
+	long := strings.Repeat("x", 200<<10)
+	in := strings.NewReader("short\n" + long + "\nafter\n" + long)
+	r, err := NewWithConfig(in, Config{Filename: "/logs/app.log", ThresholdKB: 1 << 20, MaxLineSize: 100 << 10, FS: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	kept := long[:100<<10]
+	if got, want := string(m.read(t, "/logs/app.log")), "short\n"+kept+"\nafter\n"+kept+"\n"; got != want {
+		t.Errorf("logfile holds %d bytes, want %d", len(got), len(want))
+	}
+	if got, want := r.Stats().DroppedBytes, uint64(2*(len(long)-len(kept))); got != want {
+		t.Errorf("dropped %d bytes, want %d", got, want)
+	}
+}
+
+func TestRotateEveryWrite(t *testing.T) {
+	m := newMemFS("/logs")
+	r, err := NewWithConfig(nil, Config{Filename: "/logs/app.log", ThresholdKB: 1 << 20, RotateEvery: 50 * time.Millisecond, FS: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("before\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(120 * time.Millisecond)
+	if _, err := r.Write([]byte("after\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	if got := string(gunzip(t, m.read(t, "/logs/app.log.1.gz"))); got != "before\n" {
+		t.Errorf("archive holds %q, want what was written before the boundary", got)
+	}
+	if got := string(m.read(t, "/logs/app.log")); got != "after\n" {
+		t.Errorf("logfile holds %q, want what was written after the boundary", got)
+	}
+}
+
+func TestInputPrefix(t *testing.T) {
+	m := newMemFS("/logs")
+	in := strings.NewReader(`{"ts":"2026-10-15T08:00:00Z","msg":"one"}` + "\n" +
+		`{"ts":"2026-10-15T09:00:00Z","msg":"two"}` + "\n")
+	r, err := NewWithConfig(nil, Config{
+		Filename:    "/logs/app.log",
+		ThresholdKB: 1 << 20,
+		Inputs:      []Input{{Reader: in, Prefix: "[app] "}},
+		Tags:        []Tag{{"env", "prod"}},
+		LineTime:    &LineTime{Field: "ts", Window: time.Hour},
+		FS:          m,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	want := `[app] {"env":"prod","ts":"2026-10-15T08:00:00Z","msg":"one"}` + "\n"
+	if got := string(gunzip(t, m.read(t, "/logs/app.log.1.gz"))); got != want {
+		t.Errorf("archive holds %q, want %q", got, want)
+	}
+	want = `[app] {"env":"prod","ts":"2026-10-15T09:00:00Z","msg":"two"}` + "\n"
+	if got := string(m.read(t, "/logs/app.log")); got != want {
+		t.Errorf("logfile holds %q, want %q", got, want)
+	}
+}
+
+func TestLineTimeWithSize(t *testing.T) {
+	m := newMemFS("/logs")
+	long := "2026-10-15T08:00:00Z " + strings.Repeat("x", 1100) + "\n"
+	in := strings.NewReader(long + "2026-10-15T09:00:00Z two\n2026-10-15T09:30:00Z three\n")
+	r, err := NewWithConfig(in, Config{
+		Filename:    "/logs/app.log",
+		ThresholdKB: 1,
+		LineTime:    &LineTime{Window: time.Hour},
+		FS:          m,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	// The size rotation before the second line also starts its window, so
+	// the third line, in the same window, doesn't rotate again.
+	if got := string(gunzip(t, m.read(t, "/logs/app.log.1.gz"))); got != long {
+		t.Errorf("archive holds %d bytes, want the first line", len(got))
+	}
+	if got, want := string(m.read(t, "/logs/app.log")), "2026-10-15T09:00:00Z two\n2026-10-15T09:30:00Z three\n"; got != want {
+		t.Errorf("logfile holds %q, want %q", got, want)
+	}
+}
+
+func TestFlushOnRotateWrite(t *testing.T) {
+	m := newMemFS("/logs")
+	r, err := NewWithConfig(nil, Config{Filename: "/logs/app.log", ThresholdKB: 1 << 20, FlushOnRotate: true, FS: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("one\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(m.read(t, "/logs/app.log")); got != "" {
+		t.Errorf("logfile holds %q before the rotation, want nothing", got)
+	}
+	r.Rotate()
+	if _, err := r.Write([]byte("two\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	if got := string(gunzip(t, m.read(t, "/logs/app.log.1.gz"))); got != "one\n" {
+		t.Errorf("archive holds %q, want %q", got, "one\n")
+	}
+	if got := string(m.read(t, "/logs/app.log")); got != "two\n" {
+		t.Errorf("logfile holds %q, want %q", got, "two\n")
+	}
+}
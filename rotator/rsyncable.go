@@ -0,0 +1,82 @@
+package rotator
+
+import (
+	"compress/gzip"
+	"io"
+	"time"
+)
+
+// Chunk boundaries in rsyncable output fall wherever the low bits selected
+// by rsyncMask of a rolling hash of the last rsyncWindow bytes are all zero,
+// which happens about every 64 kB.
+const (
+	rsyncWindow = 64
+	rsyncMask   = 1<<16 - 1
+	rsyncPrime  = 16777619
+)
+
+// rsyncOut is rsyncPrime to the power of rsyncWindow, the factor by which
+// the byte leaving the window has been multiplied into the hash.
+var rsyncOut = func() uint32 {
+	p := uint32(1)
+	for i := 0; i < rsyncWindow; i++ {
+		p *= rsyncPrime
+	}
+	return p
+}()
+
+// rsyncWriter writes a gzip stream as a series of gzip members, starting a
+// new member at boundaries that depend only on the content of the last
+// rsyncWindow bytes. Since each member is compressed on its own, a change
+// to the input only changes the archive up to the next boundary, and
+// delta-transfer tools like rsync find the rest of the archive unchanged.
+type rsyncWriter struct {
+	w io.Writer
+	z *gzip.Writer // current member; Reset starts the next one without a name
+
+	window [rsyncWindow]byte
+	pos    int  // index of the oldest byte in window
+	full   bool // whether window holds rsyncWindow bytes
+	hash   uint32
+}
+
+func newRsyncWriter(w io.Writer, level int, name string, modTime time.Time) (*rsyncWriter, error) {
+	z, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+	z.Name, z.ModTime = name, modTime
+	return &rsyncWriter{w: w, z: z}, nil
+}
+
+func (rw *rsyncWriter) Write(p []byte) (int, error) {
+	written := 0
+	for i, b := range p {
+		rw.hash = rw.hash*rsyncPrime + uint32(b) - uint32(rw.window[rw.pos])*rsyncOut
+		rw.window[rw.pos] = b
+		rw.pos++
+		if rw.pos == rsyncWindow {
+			rw.pos, rw.full = 0, true
+		}
+		if !rw.full || rw.hash&rsyncMask != 0 {
+			continue
+		}
+
+		n, err := rw.z.Write(p[written : i+1])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if err := rw.z.Close(); err != nil {
+			return written, err
+		}
+		rw.z.Reset(rw.w)
+	}
+
+	n, err := rw.z.Write(p[written:])
+	return written + n, err
+}
+
+func (rw *rsyncWriter) Close() error {
+	return rw.z.Close()
+}
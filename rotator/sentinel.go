@@ -0,0 +1,19 @@
+package rotator
+
+// A SentinelPosition selects where the logfile is rotated around a line
+// matching Config.RotateOn.
+type SentinelPosition int
+
+const (
+	// SentinelAfter rotates after writing the matching line, so that it
+	// ends its segment. This is the default.
+	SentinelAfter SentinelPosition = iota
+
+	// SentinelBefore rotates before writing the matching line, so that it
+	// starts a new segment.
+	SentinelBefore
+
+	// SentinelDrop rotates in place of the matching line, which is not
+	// written.
+	SentinelDrop
+)
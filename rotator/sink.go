@@ -0,0 +1,96 @@
+package rotator
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// An ArchiveSink receives a copy of each archive once it has been made, such
+// as an uploader to object storage.
+type ArchiveSink interface {
+	// Put stores the archive read from r under name, its base name. It
+	// must not return until the archive is stored.
+	Put(ctx context.Context, name string, r io.Reader) error
+}
+
+// DirSink is an ArchiveSink that copies archives into a directory, such as
+// one on another volume or where a bucket is mounted. Each archive appears
+// there atomically. If the archive read has a Stat method, as the files
+// the Rotator passes do, the copy gets its permissions, owner and group;
+// otherwise it is made readable by everyone.
+type DirSink string
+
+func (d DirSink) Put(ctx context.Context, name string, r io.Reader) error {
+	var info fs.FileInfo
+	if s, ok := r.(interface{ Stat() (fs.FileInfo, error) }); ok {
+		info, _ = s.Stat()
+	}
+	mode := os.FileMode(0644)
+	if info != nil {
+		mode = info.Mode().Perm()
+	}
+
+	path := filepath.Join(string(d), name)
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	err = f.Chmod(mode)
+	if err == nil && info != nil {
+		err = chownLike(f, info)
+	}
+	if err == nil {
+		_, err = io.Copy(f, ctxReader{ctx, r})
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// upload puts the archive arcname of job into the sink, and reports
+// whether it succeeded. An archive that can't be uploaded is kept, and the
+// upload retried later.
+func (r *Rotator) upload(job compressJob, arcname string) bool {
+	if err := r.put(arcname); err != nil {
+		r.logf("uploading %s: %v", arcname, err)
+		job.step, job.archive, job.attempts = stepUpload, arcname, 0
+		r.queueRetry(job)
+		return false
+	}
+	return true
+}
+
+// put puts the archive arcname into the sink, and removes it if
+// RemoveUploaded is set.
+func (r *Rotator) put(arcname string) error {
+	f, err := r.fs.OpenFile(arcname, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := r.stepContext()
+	defer cancel()
+	err = r.sink.Put(ctx, filepath.Base(arcname), f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if r.rmUploaded {
+		r.fs.Remove(arcname)
+		r.fs.Remove(arcname + ".gzi")
+	}
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package rotator
+
+import (
+	"io/fs"
+	"os"
+)
+
+// chownLike does nothing, as files have no Unix owner and group here.
+func chownLike(f *os.File, info fs.FileInfo) error {
+	return nil
+}
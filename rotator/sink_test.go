@@ -0,0 +1,116 @@
+package rotator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// failSink is an ArchiveSink that fails every Put.
+type failSink struct{}
+
+func (failSink) Put(ctx context.Context, name string, r io.Reader) error {
+	return errors.New("unreachable")
+}
+
+func TestUploadFailureQueued(t *testing.T) {
+	m := newMemFS("/logs")
+	r, err := NewWithConfig(nil, Config{Filename: "/logs/app.log", ThresholdKB: 1, Sink: failSink{}, RemoveUploaded: true, FS: m, ErrorLog: log.New(io.Discard, "", 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat("x", 99) + "\n"
+	for i := 0; i < 11; i++ {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	if n := r.Stats().RetryQueue; n != 1 {
+		t.Errorf("RetryQueue is %d, want the failed upload", n)
+	}
+	if names := m.names("/logs"); strings.Join(names, " ") != "app.log app.log.1.gz" {
+		t.Errorf("got files %v, want the archive kept", names)
+	}
+}
+
+func TestDirSinkMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no Unix permissions on Windows")
+	}
+	src, dst := t.TempDir(), t.TempDir()
+	arc := filepath.Join(src, "app.log.1.gz")
+	if err := os.WriteFile(arc, []byte("archive"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(arc, 0640); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(arc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := DirSink(dst).Put(context.Background(), "app.log.1.gz", f); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dst, "app.log.1.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0640 {
+		t.Errorf("copy has mode %v, want %v", mode, os.FileMode(0640))
+	}
+}
+
+func TestOnArchive(t *testing.T) {
+	m := newMemFS("/logs")
+	var calls []string
+	hook := func(ctx context.Context, segment, archive string) error {
+		calls = append(calls, filepath.Base(segment)+" "+filepath.Base(archive))
+		if len(calls) == 1 {
+			return errInjected
+		}
+		return nil
+	}
+	r, err := NewWithConfig(nil, Config{Filename: "/logs/app.log", ThresholdKB: 1, OnArchive: hook, FS: m, ErrorLog: log.New(io.Discard, "", 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat("x", 99) + "\n"
+	for i := 0; i < 31; i++ {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+
+	// Every archive is passed to the hook once Close has returned, even
+	// with nobody reading events, and the one that failed is queued.
+	var want []string
+	for i := 1; i < len(m.names("/logs")); i++ {
+		want = append(want, fmt.Sprintf("app.log.%d app.log.%d.gz", i, i))
+	}
+	if strings.Join(calls, ", ") != strings.Join(want, ", ") {
+		t.Errorf("OnArchive called with %q, want %q", calls, want)
+	}
+	if n := r.Stats().RetryQueue; n != 1 {
+		t.Errorf("RetryQueue is %d, want the failed call", n)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package rotator
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// chownLike gives f the owner and group of the file described by info, if
+// they differ from its own.
+func chownLike(f *os.File, info fs.FileInfo) error {
+	want, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	own, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if have, ok := own.Sys().(*syscall.Stat_t); ok && have.Uid == want.Uid && have.Gid == want.Gid {
+		return nil
+	}
+	return f.Chown(int(want.Uid), int(want.Gid))
+}
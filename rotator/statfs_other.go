@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd
+
+package rotator
+
+import "errors"
+
+// freeSpace is not supported on this platform.
+func freeSpace(path string) (int64, error) {
+	return 0, errors.New("free space is not available on this platform")
+}
+
+// diskSize is not supported on this platform.
+func diskSize(path string) (int64, error) {
+	return 0, errors.New("volume capacity is not available on this platform")
+}
@@ -0,0 +1,24 @@
+//go:build linux || darwin || freebsd
+
+package rotator
+
+import "syscall"
+
+// freeSpace returns the number of bytes available to unprivileged users on
+// the volume containing path.
+func freeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// diskSize returns the capacity in bytes of the volume containing path.
+func diskSize(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Blocks) * int64(st.Bsize), nil
+}
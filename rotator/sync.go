@@ -0,0 +1,59 @@
+package rotator
+
+import "time"
+
+// A SyncPolicy selects when output written to the logfile is synced to disk,
+// trading throughput for durability. The zero SyncPolicy leaves it to the
+// operating system, apart from the syncs done by FlushOnRotate. Whatever
+// the policy, output held in memory by FlushInterval or FlushOnRotate is
+// only synced once it has been written out.
+type SyncPolicy struct {
+	// EveryWrite syncs after every write to the logfile: each batch of
+	// lines read by Run, or each call to Write. It is the most durable and
+	// by far the slowest.
+	EveryWrite bool
+
+	// Bytes, if positive, syncs once this many bytes have been written
+	// since the last sync.
+	Bytes int64
+
+	// Interval, if positive, syncs at least this often while there is
+	// output that hasn't been synced.
+	Interval time.Duration
+}
+
+// wrote records that n bytes have been written to the logfile, and syncs it
+// if the SyncPolicy says it is time to.
+func (r *Rotator) wrote(n int) {
+	if n == 0 || r.syncPol == (SyncPolicy{}) {
+		return
+	}
+	r.unsynced += int64(n)
+	if r.syncPol.EveryWrite || r.syncPol.Bytes > 0 && r.unsynced >= r.syncPol.Bytes {
+		r.syncOut()
+		return
+	}
+	if r.syncPol.Interval > 0 && !r.running.Load() && r.syncT == nil {
+		// Without a Run loop to sync on a ticker, arrange for it here.
+		r.syncT = time.AfterFunc(r.syncPol.Interval, func() {
+			r.writeMu.Lock()
+			defer r.writeMu.Unlock()
+			r.syncT = nil
+			if !r.closed {
+				r.syncOut()
+			}
+		})
+	}
+}
+
+// syncOut syncs the logfile if anything has been written since it was last
+// synced. A failure is treated like a failed write.
+func (r *Rotator) syncOut() {
+	if r.unsynced == 0 {
+		return
+	}
+	r.unsynced = 0
+	if err := r.out.Sync(); err != nil {
+		r.setWrite(err)
+	}
+}
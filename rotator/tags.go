@@ -0,0 +1,97 @@
+package rotator
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+)
+
+// A Tag is a field added to every line read from the input.
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// tagger adds tags to lines. Lines holding a JSON object get the tags as
+// extra members of the object; other lines get them as a key=value prefix,
+// or are wrapped in a JSON envelope holding the tags.
+type tagger struct {
+	prefix []byte // "k1=v1 k2=v2 "
+	fields []byte // `"k1":"v1","k2":"v2"`
+
+	envelope bool
+	host     []byte // JSON string
+}
+
+// newTagger returns a tagger adding tags, wrapping plain lines in an
+// envelope naming host if envelope is set. It returns nil if there is
+// nothing to do.
+func newTagger(tags []Tag, envelope bool, host string) *tagger {
+	if len(tags) == 0 && !envelope {
+		return nil
+	}
+
+	t := &tagger{envelope: envelope}
+	t.host, _ = json.Marshal(host)
+	for i, tag := range tags {
+		t.prefix = append(t.prefix, tag.Key...)
+		t.prefix = append(t.prefix, '=')
+		t.prefix = append(t.prefix, tag.Value...)
+		t.prefix = append(t.prefix, ' ')
+
+		if i > 0 {
+			t.fields = append(t.fields, ',')
+		}
+		k, _ := json.Marshal(tag.Key)
+		v, _ := json.Marshal(tag.Value)
+		t.fields = append(t.fields, k...)
+		t.fields = append(t.fields, ':')
+		t.fields = append(t.fields, v...)
+	}
+	return t
+}
+
+// appendTagged appends the tagged form of line to buf.
+func (t *tagger) appendTagged(buf, line []byte) []byte {
+	trimmed := bytes.TrimSpace(line)
+	if len(trimmed) < 2 || trimmed[0] != '{' || trimmed[len(trimmed)-1] != '}' || !json.Valid(trimmed) {
+		if t.envelope {
+			return t.appendEnvelope(buf, line)
+		}
+		buf = append(buf, t.prefix...)
+		return append(buf, line...)
+	}
+
+	if len(t.fields) == 0 {
+		return append(buf, line...)
+	}
+
+	open := bytes.IndexByte(line, '{') + 1
+	buf = append(buf, line[:open]...)
+	buf = append(buf, t.fields...)
+	if len(bytes.TrimSpace(trimmed[1:len(trimmed)-1])) > 0 {
+		buf = append(buf, ',')
+	}
+	return append(buf, line[open:]...)
+}
+
+// appendEnvelope appends line to buf wrapped in a JSON object, along with
+// the current time, the host name and the tags.
+func (t *tagger) appendEnvelope(buf, line []byte) []byte {
+	var msg bytes.Buffer
+	enc := json.NewEncoder(&msg)
+	enc.SetEscapeHTML(false)
+	enc.Encode(string(bytes.TrimRight(line, "\r\n")))
+
+	buf = append(buf, `{"ts":"`...)
+	buf = time.Now().AppendFormat(buf, time.RFC3339Nano)
+	buf = append(buf, `","host":`...)
+	buf = append(buf, t.host...)
+	buf = append(buf, `,"message":`...)
+	buf = append(buf, bytes.TrimSuffix(msg.Bytes(), []byte("\n"))...)
+	if len(t.fields) > 0 {
+		buf = append(buf, ',')
+		buf = append(buf, t.fields...)
+	}
+	return append(buf, '}')
+}
@@ -0,0 +1,19 @@
+package rotator
+
+import "testing"
+
+func TestAppendTagged(t *testing.T) {
+	tg := newTagger([]Tag{{"env", "prod"}}, false, "")
+	tests := []struct{ in, want string }{
+		{`{"msg":"hi"}`, `{"env":"prod","msg":"hi"}`},
+		{` {} `, ` {"env":"prod"} `},
+		{`{worker-3} started {ok}`, `env=prod {worker-3} started {ok}`},
+		{`{"msg":"cut`, `env=prod {"msg":"cut`},
+		{`plain`, `env=prod plain`},
+	}
+	for _, tt := range tests {
+		if got := string(tg.appendTagged(nil, []byte(tt.in))); got != tt.want {
+			t.Errorf("appendTagged(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
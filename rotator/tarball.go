@@ -0,0 +1,143 @@
+package rotator
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tarTrailer is the end of archive marker of a tarball.
+var tarTrailer = make([]byte, 2*512)
+
+// tarName returns the path of the tarball collecting the segments rotated on
+// the day of t.
+func (r *Rotator) tarName(t time.Time) string {
+	return r.filename + "-" + t.Format("20060102") + ".tar." + r.ext
+}
+
+// appendTar adds the segment read from src, rotated at time t, to the
+// partial tarball of that day, after finalizing those of earlier days. The
+// entry is compressed as a stream of its own, so the partial tarball is
+// only ever appended to. Compression is abandoned once ctx is done.
+func (r *Rotator) appendTar(ctx context.Context, src File, t time.Time) (string, error) {
+	r.archiveMu.Lock()
+	defer r.archiveMu.Unlock()
+
+	if err := r.finalizeTars(t); err != nil {
+		return "", err
+	}
+
+	info, err := src.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	name := r.tarName(t)
+	part, err := openFile(r.fs, name+".part", os.O_CREATE|os.O_APPEND|os.O_WRONLY, r.mode)
+	if err != nil {
+		return "", err
+	}
+	partInfo, err := part.Stat()
+	if err != nil {
+		part.Close()
+		return "", err
+	}
+
+	err = r.writeTarEntry(part, ctxReader{ctx, src}, &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.Base(r.filename) + "-" + t.Format("20060102T150405.000000000"),
+		Mode:     int64(r.mode.Perm()),
+		Size:     info.Size(),
+		ModTime:  t,
+	})
+	if err != nil {
+		part.Truncate(partInfo.Size())
+		part.Close()
+		return "", err
+	}
+	return name, part.Close()
+}
+
+// writeTarEntry compresses a tar entry holding the contents of src into w,
+// leaving out the end of archive marker.
+func (r *Rotator) writeTarEntry(w io.Writer, src io.Reader, hdr *tar.Header) error {
+	z, err := r.comp.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(z)
+	if err := tw.WriteHeader(hdr); err != nil {
+		z.Close()
+		return err
+	}
+	if _, err := io.CopyN(tw, src, hdr.Size); err != nil {
+		z.Close()
+		return err
+	}
+	if err := tw.Flush(); err != nil {
+		z.Close()
+		return err
+	}
+	return z.Close()
+}
+
+// finalizeTars completes the partial tarballs of days before the day of
+// now by appending the end of archive marker and moving them into place.
+// The caller must hold archiveMu.
+func (r *Rotator) finalizeTars(now time.Time) error {
+	entries, err := r.fs.ReadDir(filepath.Dir(r.filename))
+	if err != nil {
+		return err
+	}
+
+	prefix := filepath.Base(r.filename) + "-"
+	suffix := ".tar." + r.ext + ".part"
+	today := filepath.Base(r.tarName(now)) + ".part"
+	for _, e := range entries {
+		n := e.Name()
+		if n == today || !strings.HasPrefix(n, prefix) || !strings.HasSuffix(n, suffix) {
+			continue
+		}
+		part := filepath.Join(filepath.Dir(r.filename), n)
+		if err := r.finalizeTar(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finalizeTar appends the end of archive marker to the partial tarball part
+// and moves it into place.
+func (r *Rotator) finalizeTar(part string) error {
+	name := strings.TrimSuffix(part, ".part")
+	if _, err := r.fs.Stat(name); err == nil {
+		return &os.PathError{Op: "finalize", Path: name, Err: os.ErrExist}
+	}
+
+	f, err := openFile(r.fs, part, os.O_APPEND|os.O_WRONLY, r.mode)
+	if err != nil {
+		return err
+	}
+	z, err := r.comp.NewWriter(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := z.Write(tarTrailer); err != nil {
+		z.Close()
+		f.Close()
+		return err
+	}
+	if err := z.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return r.fs.Rename(part, name)
+}
@@ -0,0 +1,23 @@
+package rotator
+
+// targetSmoothing is the weight given to the latest archive in the moving
+// average of compression ratios used by TargetArchiveSize.
+const targetSmoothing = 0.3
+
+// adaptThreshold folds the compression ratio of the latest archive, made
+// from plain bytes into compressed bytes, into the moving average, and sets
+// the threshold that makes archives of TargetArchiveSize at that ratio for
+// the next rotation to pick up. Archives are compressed one at a time, so
+// only the threshold needs to be shared.
+func (r *Rotator) adaptThreshold(plain, compressed int64) {
+	if r.zTarget <= 0 || plain <= 0 || compressed <= 0 {
+		return
+	}
+	ratio := float64(compressed) / float64(plain)
+	if r.zRatio == 0 {
+		r.zRatio = ratio
+	} else {
+		r.zRatio += targetSmoothing * (ratio - r.zRatio)
+	}
+	r.nextThr.Store(int64(float64(r.zTarget) / r.zRatio))
+}
@@ -0,0 +1,310 @@
+package rotator
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// dropProbeInterval is how long Write discards data under WriteDrop after a
+// failed write before trying the logfile again.
+const dropProbeInterval = time.Second
+
+// A WritePolicy selects how Write behaves while the logfile can't be
+// written.
+type WritePolicy int
+
+const (
+	// WriteBlock makes Write wait for every write, including any retries,
+	// and return its error. This is the default, as no data is lost without
+	// the caller knowing.
+	WriteBlock WritePolicy = iota
+
+	// WriteDrop makes Write discard data rather than hold up its caller
+	// while the logfile is failing: writes are not retried, and for a
+	// second after a write fails, data is discarded without an attempt to
+	// write it. Write then reports success regardless, and the discarded
+	// bytes are counted in Stats.
+	WriteDrop
+)
+
+// Stats holds counters describing a Rotator's operation.
+type Stats struct {
+	// DroppedBytes is the number of bytes passed to Write that were
+	// discarded under WriteDrop, plus those of lines read by Run, and of
+	// output held in memory by FlushInterval or FlushOnRotate, that could
+	// not be written, and those of lines read by Run beyond MaxLineSize.
+	DroppedBytes uint64
+
+	// DroppedLines is the number of line delimiters among DroppedBytes.
+	DroppedLines uint64
+
+	// RetryQueue is the number of failed compressions, uploads to the Sink
+	// and calls of OnArchive that are due to be retried. Each is retried up
+	// to five times, at the first rotation after a backoff that starts at 30
+	// seconds and doubles with each attempt.
+	RetryQueue int
+
+	// Lines is the number of input lines read by Run.
+	Lines uint64
+
+	// Bytes is the number of bytes written to the logfile.
+	Bytes uint64
+
+	// Rotations is the number of rotations that have taken place.
+	Rotations uint64
+
+	// PendingCompressions is the number of rotated segments waiting to be
+	// compressed or being compressed.
+	PendingCompressions int
+
+	// Compressions is the number of segments that have been compressed,
+	// and CompressionTime the time spent compressing them.
+	Compressions    uint64
+	CompressionTime time.Duration
+
+	// Size is the size of the logfile as it stands on disk, without any
+	// output held in memory by FlushInterval.
+	Size int64
+}
+
+// Stats returns the current counters.
+func (r *Rotator) Stats() Stats {
+	r.retryMu.Lock()
+	queued := len(r.retryQ)
+	r.retryMu.Unlock()
+	r.inflightMu.Lock()
+	pending := len(r.inflight)
+	r.inflightMu.Unlock()
+	var size int64
+	if info, err := r.fs.Stat(r.live); err == nil {
+		size = info.Size()
+	}
+
+	return Stats{
+		DroppedBytes:        r.dropBytes.Load(),
+		DroppedLines:        r.dropLines.Load(),
+		RetryQueue:          queued,
+		Lines:               r.nLines.Load(),
+		Bytes:               r.nBytes.Load(),
+		Rotations:           r.nRotated.Load(),
+		PendingCompressions: pending,
+		Compressions:        r.nZipped.Load(),
+		CompressionTime:     time.Duration(r.zNanos.Load()),
+		Size:                size,
+	}
+}
+
+// drop counts p as output that was discarded.
+func (r *Rotator) drop(p []byte) {
+	r.dropBytes.Add(uint64(len(p)))
+	r.dropLines.Add(uint64(bytes.Count(p, []byte{r.delim})))
+}
+
+// Write writes p to the logfile, rotating first if the threshold has been
+// reached, so that a Rotator can be used as an io.Writer without an input to
+// Run. Rotation only happens between calls, so the data of one call always
+// ends up in a single segment. What happens when the logfile can't be
+// written depends on Config.WritePolicy.
+//
+// Write may be called from several goroutines at once, as by a log.Logger
+// shared between them: calls are carried out one at a time, and never
+// overlap a rotation, so the data of separate calls is never interleaved.
+// A Rotator written to this way must not also be running Run.
+func (r *Rotator) Write(p []byte) (int, error) {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	if !r.teePrimary {
+		return r.write(p)
+	}
+
+	if _, err := os.Stdout.Write(p); err != nil {
+		return 0, err
+	}
+	// Once standard output has p, the logfile failing is no concern of the
+	// caller's.
+	if _, err := r.write(p); err != nil {
+		r.logf("%v", err)
+	}
+	return len(p), nil
+}
+
+// write does the work of Write, once p has gone to standard output with
+// TeePrimary.
+func (r *Rotator) write(p []byte) (int, error) {
+	drop := r.policy == WriteDrop
+	if drop && r.health.writeFailedWithin(dropProbeInterval) {
+		r.drop(p)
+		return len(p), nil
+	}
+
+	if err := r.reopenIfRequested(); err != nil {
+		return 0, err
+	}
+	if err := r.rotateIfRequested(); err != nil {
+		return 0, err
+	}
+	if r.every > 0 && !time.Now().Before(r.nextClock) {
+		if r.timeRotatable() {
+			if err := r.rotate("schedule"); err != nil {
+				return 0, err
+			}
+		}
+		r.nextClock = time.Now().Add(r.jittered(time.Until(nextBoundary(time.Now(), r.every))))
+	}
+	if r.size >= r.threshold {
+		if err := r.rotate("size"); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.hold {
+		// Write out what is held before taking p, so that a failure
+		// leaves p to the caller rather than discarding it with the rest.
+		if len(r.held)+len(p) >= r.holdMax {
+			if err := r.writeHeld(); err != nil && !drop {
+				return 0, err
+			}
+		}
+		if len(p) < r.holdMax {
+			r.writeLater(p)
+			return len(p), nil
+		}
+	}
+
+	if err := r.writeHeld(); err != nil && !drop {
+		return 0, err
+	}
+
+	var n int
+	var err error
+	if drop {
+		n, err = r.out.Write(p)
+		r.nBytes.Add(uint64(n))
+		r.wrote(n)
+	} else {
+		n, err = r.writeOut(p)
+	}
+	r.size += int64(n)
+	r.setWrite(err)
+	if r.meta && n > 0 {
+		r.touch()
+	}
+
+	if drop && err != nil {
+		r.drop(p[n:])
+		n, err = len(p), nil
+	}
+
+	if r.teePrimary {
+		return len(p), nil
+	}
+	if r.tee {
+		os.Stdout.Write(p[:n])
+	}
+
+	return n, err
+}
+
+// writeLater holds p to be written out with other output by FlushInterval
+// or FlushOnRotate, and arranges for FlushInterval to be kept, as there is
+// no Run loop to do it.
+func (r *Rotator) writeLater(p []byte) {
+	r.held = append(r.held, p...)
+	r.size += int64(len(p))
+	if r.meta {
+		r.touch()
+	}
+	if r.tee && !r.teePrimary {
+		os.Stdout.Write(p)
+	}
+
+	if r.flushT == nil && r.flushInt > 0 {
+		r.flushT = time.AfterFunc(r.flushInt, func() {
+			r.writeMu.Lock()
+			defer r.writeMu.Unlock()
+			r.flushT = nil
+			if !r.closed {
+				r.writeHeld()
+			}
+		})
+	}
+}
+
+// Flush writes out the output held in memory by FlushInterval or
+// FlushOnRotate. While Run is running, it only asks Run to do so, which it
+// does right away.
+func (r *Rotator) Flush() error {
+	if r.running.Load() {
+		select {
+		case r.flushReq <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	return r.writeHeld()
+}
+
+// setWrite records the outcome of a write to the logfile. A failure is sent
+// to the Events channel, and reported to the ErrorLog if the writes before
+// it succeeded.
+func (r *Rotator) setWrite(err error) {
+	if r.health.setWrite(err) {
+		r.logf("writing %s: %v", r.live, err)
+	}
+	if err != nil {
+		r.emit(Event{Type: WriteFailed, Segment: r.live, Err: err})
+	}
+}
+
+// NewWriteCloser returns a Rotator with no input, for use as the output of a
+// logging library. Closing it closes the logfile and waits for pending
+// compressions to finish. For example, with log/slog:
+//
+//	w, err := rotator.NewWriteCloser(rotator.Config{
+//		Filename:    "app.log",
+//		ThresholdKB: 10000,
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	defer w.Close()
+//
+//	logger := slog.New(slog.NewJSONHandler(w, nil))
+func NewWriteCloser(cfg Config) (io.WriteCloser, error) {
+	return NewWithConfig(nil, cfg)
+}
+
+// writeOut writes p to the logfile. Transient failures are retried with
+// exponential backoff, up to the configured number of retries, picking up
+// where a short write left off.
+func (r *Rotator) writeOut(p []byte) (int, error) {
+	written := 0
+	delay := r.retryDelay
+	for attempt := 0; ; attempt++ {
+		n, err := r.out.Write(p[written:])
+		written += n
+		if err == nil || attempt >= r.retries || !transient(err) {
+			r.nBytes.Add(uint64(written))
+			r.wrote(written)
+			return written, err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// transient reports whether a failed write is worth retrying.
+func transient(err error) bool {
+	return errors.Is(err, syscall.EINTR) ||
+		errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EIO)
+}
@@ -0,0 +1,167 @@
+package rotator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Zstd is a Compressor producing .zst archives. It compresses about as fast
+// as Gzip but considerably better, and with a dictionary trained on the
+// logfile's format it does much better still on small segments.
+type Zstd struct {
+	// Level is the compression level, from 1 to 22 as for zstd(1). Zero
+	// selects the default level.
+	Level int
+
+	// Dict is a dictionary, as trained by zstd --train, to compress with.
+	// Archives record its ID, and can only be decompressed with it.
+	Dict []byte
+}
+
+func (Zstd) Ext() string { return "zst" }
+
+func (Zstd) CanAppend() bool { return true }
+
+func (c Zstd) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	var opts []zstd.EOption
+	if c.Level != 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.Level)))
+	}
+	if c.Dict != nil {
+		opts = append(opts, zstd.WithEncoderDict(c.Dict))
+	}
+	return zstd.NewWriter(w, opts...)
+}
+
+func (c Zstd) NewReader(r io.Reader) (io.ReadCloser, error) {
+	var opts []zstd.DOption
+	if c.Dict != nil {
+		opts = append(opts, zstd.WithDecoderDicts(c.Dict))
+	}
+	d, err := zstd.NewReader(r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+// ZstdDictID returns the ID of the zstd dictionary d, or 0 if it is not a
+// valid dictionary.
+func ZstdDictID(d []byte) uint32 {
+	if len(d) < 8 || binary.LittleEndian.Uint32(d) != 0xec30a437 {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(d[4:])
+}
+
+const (
+	// dictSampleMax is how much of each segment is sampled to train a
+	// dictionary.
+	dictSampleMax = 4 << 20
+
+	// dictSamplesMax is how much is sampled to train a dictionary in all,
+	// shared evenly between the segments sampled. It is about a hundred
+	// times the size of the dictionary, as zstd recommends.
+	dictSamplesMax = 100 * dictMaxSize
+
+	// dictMaxSize is the size of trained dictionaries, zstd's default.
+	dictMaxSize = 112640
+)
+
+// loadDict loads the zstd dictionary at r.dictFile, if it exists yet.
+func (r *Rotator) loadDict() error {
+	f, err := r.fs.OpenFile(r.dictFile, os.O_RDONLY, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	d, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if ZstdDictID(d) == 0 {
+		return errors.New(r.dictFile + " is not a zstd dictionary")
+	}
+	r.zDict = d
+	return nil
+}
+
+// archiveComp returns the Compressor to compress the next archive with,
+// which uses the zstd dictionary once there is one.
+func (r *Rotator) archiveComp() Compressor {
+	if z, ok := r.comp.(Zstd); ok && r.zDict != nil {
+		z.Dict = r.zDict
+		return z
+	}
+	return r.comp
+}
+
+// sampleForDict adds the lines of the segment read from src to the samples
+// for training a zstd dictionary, if one is to be trained, and trains it
+// once enough segments have been sampled. It is called with each segment
+// before it is compressed.
+func (r *Rotator) sampleForDict(src io.ReadSeeker) {
+	if r.zDict != nil || r.dictTrain <= 0 {
+		return
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	limit := int64(dictSamplesMax / r.dictTrain)
+	if limit > dictSampleMax {
+		limit = dictSampleMax
+	}
+	b, err := io.ReadAll(io.LimitReader(src, limit))
+	if err != nil {
+		return
+	}
+	for _, line := range bytes.SplitAfter(b, []byte{r.delim}) {
+		if len(line) > 0 {
+			r.samples = append(r.samples, line)
+		}
+	}
+	r.sampled++
+	if r.sampled < r.dictTrain {
+		return
+	}
+
+	d, err := dict.BuildZstdDict(r.samples, dict.Options{MaxDictSize: dictMaxSize, HashBytes: 6})
+	r.samples = nil
+	if err == nil {
+		err = writeFileAtomic(r.fs, r.dictFile, d, r.mode)
+	}
+	if err != nil {
+		r.logf("training zstd dictionary: %v", err)
+		r.dictTrain = 0
+		return
+	}
+	r.zDict = d
+}
+
+// archiveDictID returns the ID of the zstd dictionary the archive name was
+// compressed with, going by the header of its first frame, or 0 if it was
+// compressed without one or isn't a zstd archive.
+func archiveDictID(fsys FS, name string) uint32 {
+	f, err := fsys.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	b := make([]byte, zstd.HeaderMaxSize)
+	n, _ := io.ReadFull(f, b)
+	var h zstd.Header
+	if h.Decode(b[:n]) != nil {
+		return 0
+	}
+	return h.DictionaryID
+}
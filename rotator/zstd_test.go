@@ -0,0 +1,63 @@
+package rotator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"testing"
+)
+
+func TestZstdTrainManifest(t *testing.T) {
+	m := newMemFS("/logs")
+	var errs bytes.Buffer
+	r, err := NewWithConfig(nil, Config{
+		Filename:    "/logs/app.log",
+		ThresholdKB: 16,
+		Compressor:  Zstd{},
+		ZstdDict:    "/logs/app.dict",
+		ZstdTrain:   2,
+		Manifest:    true,
+		FS:          m,
+		ErrorLog:    log.New(&errs, "", 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		line := fmt.Sprintf("level=info msg=\"request served\" id=%d path=/api/v1/items/%d status=%d took=%dms\n", i, i*7%1000, 200+i%3, i%97)
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range r.Events() {
+	}
+	if errs.Len() > 0 {
+		t.Fatalf("logged %q", errs.String())
+	}
+
+	var manifest struct {
+		Dict     *manifestDict   `json:"dict"`
+		Archives []manifestEntry `json:"archives"`
+	}
+	if err := json.Unmarshal(m.read(t, "/logs/app.log.index.json"), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Dict == nil || len(manifest.Archives) < 4 {
+		t.Fatalf("manifest has dictionary %v and %d archives, want a dictionary and several archives", manifest.Dict, len(manifest.Archives))
+	}
+	// The dictionary is trained once the second segment has been sampled,
+	// so only the first archive is compressed without it.
+	for i, a := range manifest.Archives {
+		want := manifest.Dict.ID
+		if i < 1 {
+			want = 0
+		}
+		if a.Dict != want {
+			t.Errorf("%s has dictionary %d, want %d", a.Name, a.Dict, want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// demuxBufSize is the longest line demux matches against routes in one
+// piece. The rest of a longer line follows its start.
+const demuxBufSize = 64 * 1024
+
+// A route sends the input lines matching pattern, or cond for -json-route,
+// to a logfile of its own, whose Rotator reads them from r.
+type route struct {
+	pattern  *regexp.Regexp
+	cond     *fieldCond
+	filename string
+	r        *io.PipeReader
+	w        *io.PipeWriter
+}
+
+// parseRoute parses a -route argument of the form regex=>filename, or a
+// -json-route argument of the form condition=>filename.
+func parseRoute(a routeArg) (*route, error) {
+	i := strings.LastIndex(a.arg, "=>")
+	if i < 1 || i+2 == len(a.arg) {
+		if a.json {
+			return nil, fmt.Errorf("invalid route %q: want field>=value=>filename", a.arg)
+		}
+		return nil, fmt.Errorf("invalid route %q: want regex=>filename", a.arg)
+	}
+	rt := &route{filename: a.arg[i+2:]}
+	if a.json {
+		c, err := parseCond(a.arg[:i])
+		if err != nil {
+			return nil, err
+		}
+		rt.cond = &c
+	} else {
+		pattern, err := regexp.Compile(a.arg[:i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid route %q: %v", a.arg, err)
+		}
+		rt.pattern = pattern
+	}
+	rt.r, rt.w = io.Pipe()
+	return rt, nil
+}
+
+func (rt *route) match(line *jsonLine) bool {
+	if rt.cond != nil {
+		return rt.cond.match(line)
+	}
+	return rt.pattern.Match(line.b)
+}
+
+// demux reads lines ending in delim from in and writes each to the first of
+// routes that matches it, or to def if none does. Lines that fail any of
+// filters are dropped, as are lines for a route whose writer has been
+// closed. Once in has been read, it closes def and the routes, passing on
+// any error.
+func demux(in io.Reader, delim byte, filters []fieldCond, routes []*route, def *io.PipeWriter) {
+	br := bufio.NewReaderSize(in, demuxBufSize)
+	var w io.Writer // where the rest of a long line goes
+	var err error
+	for err == nil {
+		var chunk []byte
+		chunk, err = br.ReadSlice(delim)
+		if len(chunk) == 0 {
+			continue
+		}
+		if w == nil {
+			w = def
+			line := &jsonLine{b: bytes.TrimSuffix(chunk, []byte{delim})}
+			for _, c := range filters {
+				if !c.match(line) {
+					w = io.Discard
+					break
+				}
+			}
+			for _, rt := range routes {
+				if w == def && rt.match(line) {
+					w = rt.w
+					break
+				}
+			}
+		}
+		w.Write(chunk)
+		if err == bufio.ErrBufferFull {
+			err = nil
+		} else {
+			w = nil
+		}
+	}
+
+	if err == io.EOF {
+		err = nil
+	}
+	def.CloseWithError(err)
+	for _, rt := range routes {
+		rt.w.CloseWithError(err)
+	}
+}
+
+// A jsonLine is an input line, parsed as a JSON object the first time one of
+// its members is needed.
+type jsonLine struct {
+	b      []byte
+	obj    map[string]json.RawMessage
+	parsed bool
+}
+
+// member returns the text of the top-level member name: the string itself
+// for a string, and its JSON otherwise.
+func (l *jsonLine) member(name string) (string, bool) {
+	if !l.parsed {
+		l.parsed = true
+		if json.Unmarshal(l.b, &l.obj) != nil {
+			l.obj = nil
+		}
+	}
+	raw, ok := l.obj[name]
+	if !ok {
+		return "", false
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s, true
+	}
+	return string(raw), true
+}
+
+// A fieldCond is a condition on a top-level member of the JSON object on a
+// line, as given to -json-route and -json-filter.
+type fieldCond struct {
+	field string
+	op    string
+	value string
+}
+
+// condOps are the operators of a fieldCond, with those that start with
+// another one first.
+var condOps = []string{"!=", "<=", ">=", "=", "<", ">"}
+
+// parseCond parses a condition of the form field>=value.
+func parseCond(s string) (fieldCond, error) {
+	for i := 1; i < len(s); i++ {
+		for _, op := range condOps {
+			if strings.HasPrefix(s[i:], op) {
+				return fieldCond{
+					field: strings.TrimSpace(s[:i]),
+					op:    op,
+					value: strings.TrimSpace(s[i+len(op):]),
+				}, nil
+			}
+		}
+	}
+	return fieldCond{}, fmt.Errorf("invalid condition %q: want field, one of =, !=, <, <=, > or >=, and value", s)
+}
+
+// match reports whether the line is a JSON object with a member c.field
+// that compares to c.value as c.op says. Values are compared as numbers if
+// both are numbers, by severity if both are log levels, and as strings
+// otherwise.
+func (c fieldCond) match(line *jsonLine) bool {
+	v, ok := line.member(c.field)
+	if !ok {
+		return false
+	}
+	n := compareValues(v, c.value)
+	switch c.op {
+	case "=":
+		return n == 0
+	case "!=":
+		return n != 0
+	case "<":
+		return n < 0
+	case "<=":
+		return n <= 0
+	case ">":
+		return n > 0
+	case ">=":
+		return n >= 0
+	}
+	return false
+}
+
+// levels ranks the log level names of common structured loggers, such as
+// zap, zerolog, logrus and slog, by severity.
+var levels = map[string]int{
+	"trace":    0,
+	"debug":    1,
+	"info":     2,
+	"notice":   3,
+	"warn":     4,
+	"warning":  4,
+	"error":    5,
+	"err":      5,
+	"dpanic":   6,
+	"critical": 6,
+	"crit":     6,
+	"panic":    7,
+	"fatal":    8,
+}
+
+func compareValues(a, b string) int {
+	if x, err := strconv.ParseFloat(a, 64); err == nil {
+		if y, err := strconv.ParseFloat(b, 64); err == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	if x, ok := levels[strings.ToLower(a)]; ok {
+		if y, ok := levels[strings.ToLower(b)]; ok {
+			return x - y
+		}
+	}
+	return strings.Compare(a, b)
+}
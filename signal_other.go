@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "github.com/moshee/logrotate/rotator"
+
+// notifyReopen does nothing, as there is no SIGUSR1 or SIGHUP on this
+// platform.
+func notifyReopen(r *rotator.Rotator) {}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/moshee/logrotate/rotator"
+)
+
+// notifyReopen makes SIGUSR1 reopen r's logfile, and SIGHUP rotate it.
+func notifyReopen(r *rotator.Rotator) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGHUP)
+	go func() {
+		for sig := range c {
+			if sig == syscall.SIGHUP {
+				r.Rotate()
+			} else {
+				r.Reopen()
+			}
+		}
+	}()
+}
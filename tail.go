@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/moshee/logrotate/rotator"
+)
+
+// tailPollInterval is how long a follower waits for a file to grow.
+const tailPollInterval = 250 * time.Millisecond
+
+// follower reads a file like tail -f: at the end of the file it waits for
+// more data to be appended instead of returning io.EOF.
+type follower struct {
+	f *os.File
+}
+
+func (fl follower) Read(p []byte) (int, error) {
+	for {
+		n, err := fl.f.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		time.Sleep(tailPollInterval)
+	}
+}
+
+// tailInput opens a -tail argument of the form [tag=]path, positioned at the
+// end of the file. Lines from a tagged file are prefixed with "[tag] ".
+func tailInput(arg string) (rotator.Input, error) {
+	var prefix string
+	path := arg
+	if i := strings.Index(arg, "="); i >= 0 {
+		prefix = "[" + arg[:i] + "] "
+		path = arg[i+1:]
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return rotator.Input{}, err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return rotator.Input{}, err
+	}
+	return rotator.Input{Reader: follower{f}, Prefix: prefix}, nil
+}